@@ -0,0 +1,482 @@
+// Command cli generates cross stitch charts without the GUI.
+//
+//	go run ./cmd/cli -input lavender_field.jpeg -height 40 -colors 20 -style all
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font/opentype"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/export"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/imageprocessing"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/materials"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/render"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/symbols"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/threads"
+)
+
+func main() {
+	input := flag.String("input", "", "image to convert (jpeg/jpg or png)")
+	height := flag.Int("height", 0, "chart height in stitches; 30 when no other size is given")
+	width := flag.Int("width", 0, "chart width in stitches; with -height, fitted as -fit says")
+	fitName := flag.String("fit", imageprocessing.FitInside.String(), "how the image fills -width by -height: fit inside, fill and crop, or stretch")
+	maxStitches := flag.Int("max-stitches", 0, "most stitches in the chart; alone, sizes the chart as large as this allows (0 is no limit)")
+	finishedSize := flag.Float64("size", 0, "finished height in -unit; overrides -height using the fabric count")
+	finishedWidth := flag.Float64("size-width", 0, "finished width in -unit; overrides -width using the fabric count")
+	unitName := flag.String("unit", materials.Inches.String(), "unit of -size: in or cm")
+	numColors := flag.Int("colors", 30, "number of thread colors")
+	blends := flag.Int("blends", 0, "most blended threads, one strand each of two palette threads, to add to the colors")
+	style := flag.String("style", "all", "chart style: filled, symbol, xstitch or all")
+	brand := flag.String("brand", threads.BrandDMC, "thread brand to generate with")
+	palettePath := flag.String("palette", "", "thread palette file (tsv, csv or json) for -brand, instead of its library in assets")
+	var libraries libraryFlags
+	flag.Var(&libraries, "library", "extra thread library as Brand=path; may be repeated")
+	convertTo := flag.String("convert-to", "", "convert the chart to the nearest threads of another brand")
+	mismatch := flag.Float64("mismatch", threads.DefaultMismatchThreshold, "CIEDE2000 difference reported as a poor match by -convert-to")
+	outputDir := flag.String("output", "output", "directory to write charts into")
+	symbolSetName := flag.String("symbols", symbols.SetSymbols.String(), "chart symbol set: symbols, letters, shapes or custom")
+	symbolFile := flag.String("symbol-file", "", "custom symbol list, one line of look-alike symbols per line; implies -symbols custom")
+	fontPath := flag.String("font", "assets/DejaVuSans.ttf", "TrueType font used for symbols")
+	metricName := flag.String("metric", colormath.MetricCIEDE2000.String(), "color matching metric: RGB, CIE76, CIE94 or CIEDE2000")
+	methodName := flag.String("method", imageprocessing.QuantizePopularity.String(), "palette method: popularity, mediancut, kmeans or octree")
+	ditherName := flag.String("dither", imageprocessing.DitherNone.String(), "dithering: none, floyd-steinberg, atkinson, jarvis-judice-ninke, sierra or bayer")
+	ditherStrength := flag.Float64("dither-strength", 1, "dithering strength from 0 to 1")
+	title := flag.String("title", "", "pattern title saved in pattern.json")
+	author := flag.String("author", "", "pattern author saved in pattern.json")
+	writeSVG := flag.Bool("svg", false, "also write an SVG chart for each style")
+	writePDF := flag.Bool("pdf", false, "also write a printable multi-page chart.pdf")
+	writeOXS := flag.Bool("oxs", false, "also write chart.oxs for other stitching software")
+	fabricName := flag.String("fabric", "14", "fabric count such as 11, 14, 16, 18, 22, 28-over-2 or 28x26-over-2 for uneven weaves, for size and thread estimates")
+	strands := flag.Int("strands", 2, "strands of floss per stitch, for thread estimates")
+	waste := flag.Float64("waste", 1.2, "thread waste factor, for thread estimates")
+	pricesPath := flag.String("prices", "", "optional price table of \"<thread id>,<price per skein>\" lines")
+	stashPath := flag.String("stash", "", "optional stash of \"<thread>,<skeins>\" lines; writes shopping.txt")
+	stashOnly := flag.Bool("stash-only", true, "with -stash, only use threads in the stash")
+	alphaThreshold := flag.Int("alpha-threshold", imageprocessing.DefaultAlphaThreshold, "leave pixels with alpha below this (0-255) unstitched")
+	removeBackground := flag.Bool("remove-background", false, "leave the uniform background around the subject, found from the image's edges, unstitched")
+	backgroundTolerance := flag.Float64("background-tolerance", imageprocessing.DefaultBackgroundTolerance, "CIE76 difference from the background color still removed by -remove-background")
+	resamplerName := flag.String("resample", imageprocessing.DefaultResampler.String(), "resampling used to scale the image: nearest, box, bilinear, catmullrom or lanczos")
+	pixelArt := flag.Bool("pixel-art", false, "treat the image as scaled up pixel art and make one stitch per native pixel; overrides the other sizes")
+	cropSpec := flag.String("crop", "", "keep only this part of the image, as x,y,width,height in pixels")
+	rotationName := flag.String("rotate", "0", "rotate the image clockwise by 0, 90, 180 or 270 degrees")
+	flipHorizontal := flag.Bool("flip-h", false, "mirror the image left to right")
+	flipVertical := flag.Bool("flip-v", false, "mirror the image top to bottom")
+	brightness := flag.Float64("brightness", 0, "brightness change from -1 to 1")
+	contrast := flag.Float64("contrast", 0, "contrast change from -1 to 1")
+	gamma := flag.Float64("gamma", 1, "gamma correction; above 1 brightens mid tones")
+	saturation := flag.Float64("saturation", 0, "saturation change from -1 (gray) to 1")
+	posterize := flag.Int("posterize", 0, "limit each color channel to this many levels before reducing colors (0 disables)")
+	sharpen := flag.Float64("sharpen", 0, "unsharp mask amount applied to the resized image (0 disables)")
+	sharpenRadius := flag.Float64("sharpen-radius", imageprocessing.DefaultSharpenRadius, "unsharp mask radius in stitches")
+	savePreprocessed := flag.Bool("save-preprocessed", false, "also write preprocessed.png, the resized and adjusted image colors are reduced from")
+	minRegion := flag.Int("min-region", 1, "merge single-color regions smaller than this many stitches (1 disables)")
+	flag.Parse()
+
+	if *input == "" {
+		usageError("missing -input")
+	}
+	switch {
+	case *numColors <= 0:
+		usageError(fmt.Sprintf("-colors must be positive, got %d", *numColors))
+	case *blends < 0:
+		usageError(fmt.Sprintf("-blends must not be negative, got %d", *blends))
+	case *width < 0 || *height < 0 || *maxStitches < 0:
+		usageError("-width, -height and -max-stitches must not be negative")
+	case *finishedSize < 0 || *finishedWidth < 0:
+		usageError("-size and -size-width must not be negative")
+	case *minRegion < 1:
+		usageError(fmt.Sprintf("-min-region must be at least 1, got %d", *minRegion))
+	case *strands <= 0:
+		usageError(fmt.Sprintf("-strands must be positive, got %d", *strands))
+	case *waste <= 0:
+		usageError(fmt.Sprintf("-waste must be positive, got %g", *waste))
+	case *ditherStrength < 0 || *ditherStrength > 1:
+		usageError(fmt.Sprintf("-dither-strength must be between 0 and 1, got %g", *ditherStrength))
+	case *posterize < 0:
+		usageError(fmt.Sprintf("-posterize must not be negative, got %d", *posterize))
+	}
+
+	metric, err := colormath.ParseMetric(*metricName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	method, err := imageprocessing.ParseQuantizeMethod(*methodName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dither, err := imageprocessing.ParseDitherMode(*ditherName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	styles, err := parseStyles(*style)
+	if err != nil {
+		log.Fatal(err)
+	}
+	symbolSet, err := symbols.ParseSet(*symbolSetName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	symbolList := symbolSet.Symbols()
+	if *symbolFile != "" {
+		symbolList, err = symbols.LoadCustom(*symbolFile)
+		if err != nil {
+			log.Fatalf("failed to load symbols: %s", err)
+		}
+	} else if symbolSet == symbols.SetCustom {
+		log.Fatal("-symbols custom needs -symbol-file")
+	}
+	fabric, err := materials.ParseFabric(*fabricName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	unit, err := materials.ParseUnit(*unitName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *alphaThreshold < 0 || *alphaThreshold > 255 {
+		log.Fatalf("-alpha-threshold must be between 0 and 255, got %d", *alphaThreshold)
+	}
+	preprocess := imageprocessing.PreprocessOptions{
+		FlipHorizontal: *flipHorizontal,
+		FlipVertical:   *flipVertical,
+		Brightness:     *brightness,
+		Contrast:       *contrast,
+		Saturation:     *saturation,
+		Gamma:          *gamma,
+		SharpenAmount:  *sharpen,
+		SharpenRadius:  *sharpenRadius,
+		Posterize:      *posterize,
+	}
+	resampler, err := imageprocessing.ParseResampler(*resamplerName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fit, err := imageprocessing.ParseFitMode(*fitName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	preprocess.Rotation, err = imageprocessing.ParseRotation(*rotationName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cropSpec != "" {
+		preprocess.Crop, err = imageprocessing.ParseCrop(*cropSpec)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fabricCount := int(math.Round(fabric.StitchesPerInch()))
+	materialOpts := materials.Options{FabricCount: fabricCount, Strands: *strands, WasteFactor: *waste}
+	if *pricesPath != "" {
+		materialOpts.Prices, err = materials.LoadPrices(*pricesPath)
+		if err != nil {
+			log.Fatalf("failed to load prices: %s", err)
+		}
+	}
+
+	img, err := imageprocessing.LoadImage(*input)
+	if err != nil {
+		log.Fatalf("failed to load image: %s", err)
+	}
+	sourceHash, err := hashFile(*input)
+	if err != nil {
+		log.Fatalf("failed to hash image: %s", err)
+	}
+	registry, err := loadRegistry(*brand, *palettePath, libraries)
+	if err != nil {
+		log.Fatalf("failed to load thread colors: %s", err)
+	}
+	library, err := registry.Library(*brand)
+	if err != nil {
+		log.Fatal(err)
+	}
+	threadColors := library.Threads
+
+	var stash *materials.Stash
+	if *stashPath != "" {
+		stash, err = materials.LoadStash(*stashPath)
+		if err != nil {
+			log.Fatalf("failed to load stash: %s", err)
+		}
+		if *stashOnly {
+			threadColors = stash.Filter(threadColors)
+			if len(threadColors) == 0 {
+				log.Fatalf("the stash has no %s threads", library.Brand)
+			}
+		}
+	}
+	fontBytes, err := os.ReadFile(*fontPath)
+	if err != nil {
+		log.Fatalf("failed to load font: %s", err)
+	}
+	symbolFont, err := opentype.Parse(fontBytes)
+	if err != nil {
+		log.Fatalf("failed to parse font: %s", err)
+	}
+
+	img, err = imageprocessing.Orient(img, preprocess)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sizing := imageprocessing.ResizeOptions{
+		Width:        *width,
+		Height:       *height,
+		Fit:          fit,
+		MaxStitches:  *maxStitches,
+		StitchAspect: fabric.StitchAspect(),
+		Resampler:    resampler,
+	}
+	if *finishedSize > 0 {
+		sizing.Height = materials.StitchesDown(*finishedSize, unit, fabric)
+	}
+	if *finishedWidth > 0 {
+		sizing.Width = materials.StitchesAcross(*finishedWidth, unit, fabric)
+	}
+	if sizing.Width == 0 && sizing.Height == 0 && sizing.MaxStitches == 0 {
+		sizing.Height = 30
+	}
+	chartWidth, chartHeight := imageprocessing.ChartSize(img.Bounds(), sizing)
+	if *pixelArt {
+		grid := imageprocessing.DetectPixelGrid(img)
+		img = imageprocessing.SamplePixelGrid(img, grid)
+		chartWidth, chartHeight = img.Bounds().Dx(), img.Bounds().Dy()
+		log.Printf("pixel art of %d × %d source pixels per stitch", grid.Cell.X, grid.Cell.Y)
+	}
+	log.Print(materials.SizeSummary(chartWidth, chartHeight, fabric, unit))
+
+	if *removeBackground {
+		var report imageprocessing.BackgroundReport
+		img, report = imageprocessing.RemoveBackground(img, *backgroundTolerance)
+		if report.Found {
+			log.Printf("background removal cleared %d pixels around #%02x%02x%02x", report.PixelsCleared, report.Color.R, report.Color.G, report.Color.B)
+		} else {
+			log.Print("background removal found no uniform background along the edges")
+		}
+	}
+	resizedImg := img
+	if !*pixelArt {
+		resizedImg = imageprocessing.ResizeImage(img, sizing)
+	}
+	resizedImg = imageprocessing.Adjust(resizedImg, preprocess)
+	resizedImg = imageprocessing.ClearTransparent(resizedImg, uint8(*alphaThreshold))
+	if *savePreprocessed {
+		preprocessedPath := filepath.Join(*outputDir, "preprocessed.png")
+		if err := os.MkdirAll(*outputDir, os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+		if err := imageprocessing.SaveImage(preprocessedPath, resizedImg); err != nil {
+			log.Fatalf("failed to save preprocessed image: %s", err)
+		}
+		fmt.Println(preprocessedPath)
+	}
+	threadPalette := imageprocessing.GetPartialPalette(resizedImg, threadColors, imageprocessing.PaletteOptions{
+		NumColors: *numColors,
+		Method:    method,
+		Metric:    metric,
+		Blends:    *blends,
+	})
+	reducedImg := imageprocessing.ReduceColors(resizedImg, threadPalette, imageprocessing.ReduceOptions{
+		Metric:         metric,
+		Dither:         dither,
+		DitherStrength: *ditherStrength,
+	})
+	if *minRegion > 1 {
+		var report imageprocessing.CleanupReport
+		reducedImg, report = imageprocessing.RemoveConfetti(reducedImg, *minRegion, metric)
+		log.Printf("confetti cleanup merged %d regions (%d stitches changed)", report.RegionsMerged, report.CellsChanged)
+	}
+
+	colorGrid := imageprocessing.GenerateColorGrid(reducedImg, threadPalette, true)
+	pattern := common.NewPattern(colorGrid)
+	if *convertTo != "" {
+		target, err := registry.Library(*convertTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var report threads.ConversionReport
+		pattern, report = threads.Convert(pattern, target, metric, *mismatch)
+		if err := report.WriteText(os.Stderr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := symbols.AssignForFont(pattern, symbolList, symbolFont); err != nil {
+		if needsSymbols(styles, *writePDF, *writeSVG, *writeOXS) {
+			log.Fatal(err)
+		}
+		log.Printf("warning: %s; continuing without chart symbols", err)
+	}
+	colorGrid = pattern.Grid()
+
+	renderer := render.NewRenderer(symbolFont, render.StyleFilled)
+	if err := renderer.SaveCharts(*outputDir, colorGrid, styles); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, s := range styles {
+		fmt.Println(filepath.Join(*outputDir, s.FileName()))
+	}
+
+	pattern.Metadata = common.PatternMetadata{
+		Title:           *title,
+		Author:          *author,
+		FabricCount:     fabricCount,
+		SourceImageHash: sourceHash,
+	}
+	patternPath := filepath.Join(*outputDir, "pattern.json")
+	if err := pattern.SaveFile(patternPath); err != nil {
+		log.Fatalf("failed to save pattern: %s", err)
+	}
+	fmt.Println(patternPath)
+
+	legendPath := filepath.Join(*outputDir, "legend.csv")
+	if err := export.SaveLegendCSV(legendPath, pattern, materialOpts); err != nil {
+		log.Fatalf("failed to save legend: %s", err)
+	}
+	fmt.Println(legendPath)
+
+	if stash != nil {
+		shoppingPath := filepath.Join(*outputDir, "shopping.txt")
+		if err := writeShoppingList(shoppingPath, pattern, materialOpts, stash); err != nil {
+			log.Fatalf("failed to save shopping list: %s", err)
+		}
+		fmt.Println(shoppingPath)
+	}
+
+	if *writeSVG {
+		for _, s := range styles {
+			svgPath := filepath.Join(*outputDir, strings.TrimSuffix(s.FileName(), filepath.Ext(s.FileName()))+".svg")
+			if err := export.SaveSVG(svgPath, pattern, export.SVGOptions{Style: s}); err != nil {
+				log.Fatalf("failed to save svg: %s", err)
+			}
+			fmt.Println(svgPath)
+		}
+	}
+
+	if *writePDF {
+		pdfPath := filepath.Join(*outputDir, "chart.pdf")
+		if err := export.SavePDF(pdfPath, pattern, export.PDFOptions{Font: fontBytes, FabricCount: fabricCount, Materials: materialOpts}); err != nil {
+			log.Fatalf("failed to save pdf: %s", err)
+		}
+		fmt.Println(pdfPath)
+	}
+
+	if *writeOXS {
+		oxsPath := filepath.Join(*outputDir, "chart.oxs")
+		if err := export.SaveOXS(oxsPath, pattern); err != nil {
+			log.Fatalf("failed to save oxs: %s", err)
+		}
+		fmt.Println(oxsPath)
+	}
+}
+
+// usageError reports a missing or invalid flag with the usage message and
+// exits.
+func usageError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// libraryFlags collects repeated -library flags.
+type libraryFlags []string
+
+func (l *libraryFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *libraryFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// loadRegistry loads the libraries in assets, any extra -library files and
+// -palette as the library of brand.
+func loadRegistry(brand, palettePath string, libraries []string) (*threads.Registry, error) {
+	registry, err := threads.LoadRegistry("assets")
+	if err != nil {
+		log.Print(err)
+	}
+	for _, spec := range libraries {
+		libBrand, path, err := threads.ParseLibrarySpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		lib, err := threads.LoadLibrary(libBrand, path)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(lib)
+	}
+	if palettePath != "" {
+		lib, err := threads.LoadLibrary(brand, palettePath)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(lib)
+	}
+	return registry, nil
+}
+
+// writeShoppingList writes what the pattern uses of the stash and what has
+// to be bought.
+func writeShoppingList(path string, pattern *common.Pattern, opts materials.Options, stash *materials.Stash) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report := materials.CompareStash(materials.EstimatePattern(pattern, opts), stash, opts.Prices)
+	if err := report.WriteText(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return common.HashSource(file)
+}
+
+// needsSymbols reports whether any requested output shows chart symbols:
+// a symbol or cross stitch chart, or a PDF, SVG or OXS file.
+func needsSymbols(styles []render.Style, pdf, svg, oxs bool) bool {
+	for _, s := range styles {
+		if s != render.StyleFilled {
+			return true
+		}
+	}
+	return pdf || svg || oxs
+}
+
+// parseStyles turns a comma separated list of style names, or "all", into
+// chart styles.
+func parseStyles(value string) ([]render.Style, error) {
+	if value == "all" {
+		return render.Styles, nil
+	}
+
+	var styles []render.Style
+	for _, name := range strings.Split(value, ",") {
+		s, err := render.ParseStyle(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		styles = append(styles, s)
+	}
+	return styles, nil
+}
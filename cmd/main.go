@@ -1,14 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -19,19 +21,87 @@ import (
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
-	"golang.org/x/image/math/fixed"
 
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
 	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/export"
 	"github.com/Kytlin/Cross-stitch-image-generator/pkg/imageprocessing"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/materials"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/render"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/symbols"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/threads"
 )
 
 var currentImage image.Image
 
-var showSymbol, useStitch bool
+var chartStyle = render.StyleFilled
+
+var colorMetric = colormath.MetricCIEDE2000
+var quantizeMethod = imageprocessing.QuantizePopularity
+var ditherMode = imageprocessing.DitherNone
+
+// threadRegistry holds the thread libraries in assets; charts are generated
+// with threadBrand's library.
+var threadRegistry = threads.NewRegistry()
+var threadBrand = threads.BrandDMC
+
+// threadStash is the thread the user owns; when stashOnly is set charts
+// only use threads in it.
+var threadStash *materials.Stash
+var stashOnly bool
+
+// sizeBy picks which of chartHeight, chartWidth and maxStitches size
+// charts, in stitches; fitMode fits the image when both chartWidth and
+// chartHeight do.
+var sizeBy = sizeByHeight
+var chartHeight = 30
+var chartWidth = 40
+var maxStitches = 10000
+var fitMode = imageprocessing.FitInside
+
+// The ways charts can be sized, as offered in the Size By select.
+const (
+	sizeByHeight = "Height"
+	sizeByWidth  = "Width"
+	sizeByBoth   = "Width and Height"
+	sizeByCount  = "Stitch Count"
+)
+
+var sizeByOptions = []string{sizeByHeight, sizeByWidth, sizeByBoth, sizeByCount}
+
+// fabric, sizeUnit and finishedHeight size charts physically. A zero
+// finishedHeight sizes charts by the height slider instead.
+var fabric = materials.DefaultFabric
+var sizeUnit = materials.Inches
+var finishedHeight float64
+
+// threadMaterials holds the strands and prices used for the legend's thread
+// estimates; the fabric count comes from the current pattern.
+var threadMaterials = materials.Options{Strands: 2}
+
+// alphaThreshold is the alpha below which image pixels are left unstitched.
+var alphaThreshold uint8 = imageprocessing.DefaultAlphaThreshold
+
+// removeBackground clears the background connected to the image's border,
+// within backgroundTolerance of its color, before the chart is made.
+var removeBackground bool
+var backgroundTolerance = imageprocessing.DefaultBackgroundTolerance
+
+// preprocessing crops, orients and adjusts the image before it is charted.
+var preprocessing imageprocessing.PreprocessOptions
 
-var threadPalette []common.ThreadColor
+// resampler scales images to the chart size. In pixelArt mode images are
+// instead reduced to their native pixels, one per stitch.
+var resampler = imageprocessing.DefaultResampler
+var pixelArt bool
+
+// symbolList is the symbols handed out to generated charts, most used
+// thread first.
+var symbolList = symbols.SetSymbols.Symbols()
+
+var currentImageHash string
+var currentPattern *common.Pattern
 var rectangles [][]*canvas.Rectangle
 
 // loadCustomFont reads and loads a TTF font from the given path.
@@ -77,30 +147,135 @@ func main() {
 	fontPath := "assets/DejaVuSans.ttf"
 	customFont, err := loadCustomFont(fontPath)
 	if err != nil {
-		fmt.Println("Failed to load custom font: %v", err)
+		fmt.Printf("Failed to load custom font: %v\n", err)
 	}
 
 	// Apply custom font
 	customFontResource := fyne.NewStaticResource("CustomFont", customFont)
 	fyne.CurrentApp().Settings().SetTheme(&myTheme{font: customFontResource})
 
+	// Chart renderer; symbols are skipped if the font failed to load
+	symbolFont, err := opentype.Parse(customFont)
+	if err != nil {
+		fmt.Printf("Failed to parse custom font: %v\n", err)
+	}
+	renderer := render.NewRenderer(symbolFont, chartStyle)
+
+	// Thread libraries; any that fail to load are reported once the
+	// window is shown and left out of the brand list
+	registry, registryErr := threads.LoadRegistry("assets")
+	threadRegistry = registry
+
 	// Image processing UI components
 	label := widget.NewLabel("Select a folder to upload an image:")
 
+	// Finished size
+	sizeLabel := widget.NewLabel("")
+
 	// HEIGHT
-	defaultHeight := 30.0
 	heightValue := binding.NewFloat()
-	heightValue.Set(defaultHeight)
-	heightSlider := widget.NewSliderWithData(10.0, 50.0, heightValue)
+	heightValue.Set(float64(chartHeight))
+	heightSlider := widget.NewSliderWithData(10.0, 500.0, heightValue)
 
 	// Custom label to display integer value
 	heightLabel := widget.NewLabelWithData(binding.NewString())
 	heightValue.AddListener(binding.NewDataListener(func() {
 		floatVal, _ := heightValue.Get()
-		intVal := int(floatVal)
-		heightLabel.SetText("Height:\t " + strconv.Itoa(intVal))
+		chartHeight = int(floatVal)
+		heightLabel.SetText("Height:\t " + strconv.Itoa(chartHeight))
+		updateSizeLabel(sizeLabel)
+	}))
+
+	// Width
+	widthValue := binding.NewFloat()
+	widthValue.Set(float64(chartWidth))
+	widthSlider := widget.NewSliderWithData(10.0, 500.0, widthValue)
+
+	widthLabel := widget.NewLabelWithData(binding.NewString())
+	widthValue.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := widthValue.Get()
+		chartWidth = int(floatVal)
+		widthLabel.SetText("Width:\t " + strconv.Itoa(chartWidth))
+		updateSizeLabel(sizeLabel)
 	}))
 
+	// Stitch count
+	maxStitchesValue := binding.NewFloat()
+	maxStitchesValue.Set(float64(maxStitches))
+	maxStitchesSlider := widget.NewSliderWithData(1000.0, 250000.0, maxStitchesValue)
+	maxStitchesSlider.Step = 1000
+
+	maxStitchesLabel := widget.NewLabelWithData(binding.NewString())
+	maxStitchesValue.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := maxStitchesValue.Get()
+		maxStitches = int(floatVal)
+		maxStitchesLabel.SetText("Stitch Count:\t up to " + strconv.Itoa(maxStitches))
+		updateSizeLabel(sizeLabel)
+	}))
+
+	fitNames := make([]string, len(imageprocessing.FitModes))
+	for i, f := range imageprocessing.FitModes {
+		fitNames[i] = f.String()
+	}
+	fitLabel := widget.NewLabel("Fit:")
+	fitSelect := widget.NewSelect(fitNames, func(value string) {
+		if f, err := imageprocessing.ParseFitMode(value); err == nil {
+			fitMode = f
+		}
+		updateSizeLabel(sizeLabel)
+	})
+	fitSelect.SetSelected(fitMode.String())
+
+	sizeByLabel := widget.NewLabel("Size By:")
+	sizeBySelect := widget.NewSelect(sizeByOptions, func(value string) {
+		sizeBy = value
+		setVisible(sizeBy == sizeByHeight || sizeBy == sizeByBoth, heightLabel, heightSlider)
+		setVisible(sizeBy == sizeByWidth || sizeBy == sizeByBoth, widthLabel, widthSlider)
+		setVisible(sizeBy == sizeByBoth, fitLabel, fitSelect)
+		setVisible(sizeBy == sizeByCount, maxStitchesLabel, maxStitchesSlider)
+		updateSizeLabel(sizeLabel)
+	})
+	sizeBySelect.SetSelected(sizeBy)
+
+	fabricNames := make([]string, len(materials.Fabrics))
+	for i, f := range materials.Fabrics {
+		fabricNames[i] = f.String()
+	}
+	fabricLabel := widget.NewLabel("Fabric:")
+	// Other fabrics can be typed in, such as 28x26-over-2 for an uneven
+	// weave
+	fabricSelect := widget.NewSelectEntry(fabricNames)
+	fabricSelect.OnChanged = func(value string) {
+		if f, err := materials.ParseFabric(value); err == nil {
+			fabric = f
+		}
+		updateSizeLabel(sizeLabel)
+	}
+	fabricSelect.SetText(fabric.String())
+
+	finishedHeightLabel := widget.NewLabel("Finished Height:")
+	finishedHeightEntry := widget.NewEntry()
+	finishedHeightEntry.SetPlaceHolder("use slider")
+	finishedHeightEntry.OnChanged = func(value string) {
+		finishedHeight = 0
+		if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v > 0 {
+			finishedHeight = v
+		}
+		updateSizeLabel(sizeLabel)
+	}
+
+	unitNames := make([]string, len(materials.Units))
+	for i, u := range materials.Units {
+		unitNames[i] = u.String()
+	}
+	unitSelect := widget.NewSelect(unitNames, func(value string) {
+		if u, err := materials.ParseUnit(value); err == nil {
+			sizeUnit = u
+		}
+		updateSizeLabel(sizeLabel)
+	})
+	unitSelect.SetSelected(sizeUnit.String())
+
 	// NUM colorS
 	defaultNumColors := 30.0
 	numColors := binding.NewFloat()
@@ -117,16 +292,121 @@ func main() {
 
 	gridDownloadChoice := widget.NewRadioGroup([]string{"Filled color and symbol", "Filled color", "X stitch"}, func(value string) {
 		if value == "Filled color and symbol" {
-			showSymbol = true
-			useStitch = false
+			chartStyle = render.StyleSymbol
 		} else if value == "X stitch" {
-			showSymbol = false
-			useStitch = true
+			chartStyle = render.StyleXStitch
 		} else {
-			showSymbol = false
-			useStitch = false
+			chartStyle = render.StyleFilled
+		}
+	})
+
+	// Thread brand
+	brandLabel := widget.NewLabel("Thread Brand:")
+	brandSelect := widget.NewSelect(threadRegistry.Brands(), func(value string) {
+		threadBrand = value
+	})
+	brandSelect.SetSelected(threadBrand)
+
+	// Color matching metric
+	metricNames := make([]string, len(colormath.Metrics))
+	for i, m := range colormath.Metrics {
+		metricNames[i] = m.String()
+	}
+	metricLabel := widget.NewLabel("Color Matching:")
+	metricSelect := widget.NewSelect(metricNames, func(value string) {
+		if m, err := colormath.ParseMetric(value); err == nil {
+			colorMetric = m
 		}
 	})
+	metricSelect.SetSelected(colorMetric.String())
+
+	// Palette quantization method
+	methodNames := make([]string, len(imageprocessing.QuantizeMethods))
+	for i, q := range imageprocessing.QuantizeMethods {
+		methodNames[i] = q.String()
+	}
+	methodLabel := widget.NewLabel("Palette Method:")
+	methodSelect := widget.NewSelect(methodNames, func(value string) {
+		if q, err := imageprocessing.ParseQuantizeMethod(value); err == nil {
+			quantizeMethod = q
+		}
+	})
+	methodSelect.SetSelected(quantizeMethod.String())
+
+	// Dithering
+	ditherNames := make([]string, len(imageprocessing.DitherModes))
+	for i, d := range imageprocessing.DitherModes {
+		ditherNames[i] = d.String()
+	}
+	ditherLabel := widget.NewLabel("Dithering:")
+	ditherSelect := widget.NewSelect(ditherNames, func(value string) {
+		if d, err := imageprocessing.ParseDitherMode(value); err == nil {
+			ditherMode = d
+		}
+	})
+	ditherSelect.SetSelected(ditherMode.String())
+
+	defaultDitherStrength := 1.0
+	ditherStrength := binding.NewFloat()
+	ditherStrength.Set(defaultDitherStrength)
+	ditherStrengthSlider := widget.NewSliderWithData(0.0, 1.0, ditherStrength)
+	ditherStrengthSlider.Step = 0.05
+
+	ditherStrengthLabel := widget.NewLabelWithData(binding.NewString())
+	ditherStrength.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := ditherStrength.Get()
+		ditherStrengthLabel.SetText("Dither Strength: " + strconv.Itoa(int(floatVal*100+0.5)) + "%")
+	}))
+
+	// Blended threads
+	blends := binding.NewFloat()
+	blendsSlider := widget.NewSliderWithData(0.0, 10.0, blends)
+
+	blendsLabel := widget.NewLabelWithData(binding.NewString())
+	blends.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := blends.Get()
+		intVal := int(floatVal)
+		if intVal == 0 {
+			blendsLabel.SetText("Blended Threads: off")
+			return
+		}
+		blendsLabel.SetText("Blended Threads: up to " + strconv.Itoa(intVal))
+	}))
+
+	// Transparency
+	opacity := binding.NewFloat()
+	opacity.Set(math.Round(float64(alphaThreshold) * 100 / 255))
+	opacitySlider := widget.NewSliderWithData(0.0, 100.0, opacity)
+
+	opacityLabel := widget.NewLabelWithData(binding.NewString())
+	opacity.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := opacity.Get()
+		alphaThreshold = uint8(math.Round(floatVal * 255 / 100))
+		opacityLabel.SetText("Leave Unstitched Below Opacity: " + strconv.Itoa(int(floatVal)) + "%")
+	}))
+
+	// Confetti cleanup
+	defaultMinRegion := 1.0
+	minRegion := binding.NewFloat()
+	minRegion.Set(defaultMinRegion)
+	minRegionSlider := widget.NewSliderWithData(1.0, 10.0, minRegion)
+
+	minRegionLabel := widget.NewLabelWithData(binding.NewString())
+	minRegion.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := minRegion.Get()
+		intVal := int(floatVal)
+		if intVal <= 1 {
+			minRegionLabel.SetText("Confetti Cleanup: off")
+			return
+		}
+		minRegionLabel.SetText("Confetti Cleanup: merge regions under " + strconv.Itoa(intVal) + " stitches")
+	}))
+
+	// Pattern metadata
+	titleEntry := widget.NewEntry()
+	titleEntry.SetPlaceHolder("Title")
+	authorEntry := widget.NewEntry()
+	authorEntry.SetPlaceHolder("Author")
 
 	// Image display canvas
 	imageCanvas := canvas.NewImageFromImage(nil)
@@ -136,27 +416,225 @@ func main() {
 	legendContainer := container.NewVBox()
 	legendContainer.Hide()
 
-	uploadButton, resizeButton, generateButton := getUploadAndGenerateButtons(heightSlider, numColorsSlider, myWindow, imageCanvas, customFont, legendContainer)
+	// Chart symbols
+	symbolLabel := widget.NewLabel("Symbols:")
+	symbolSelect := getSymbolSelect(myWindow, imageCanvas, renderer, legendContainer)
+
+	// Background removal
+	previewLabel := widget.NewLabel("")
+	preview := func() {
+		previewChart(imageCanvas, renderer, previewLabel)
+	}
+	backgroundCheck := widget.NewCheck("Remove Background", func(checked bool) {
+		removeBackground = checked
+		preview()
+	})
+	tolerance := binding.NewFloat()
+	tolerance.Set(backgroundTolerance)
+	toleranceSlider := widget.NewSliderWithData(1.0, 40.0, tolerance)
+	toleranceSlider.OnChangeEnded = func(float64) {
+		if removeBackground {
+			preview()
+		}
+	}
+
+	toleranceLabel := widget.NewLabelWithData(binding.NewString())
+	tolerance.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := tolerance.Get()
+		backgroundTolerance = floatVal
+		toleranceLabel.SetText("Background Tolerance: " + strconv.Itoa(int(floatVal)))
+	}))
+
+	// Resampling
+	resamplerNames := make([]string, len(imageprocessing.Resamplers))
+	for i, r := range imageprocessing.Resamplers {
+		resamplerNames[i] = r.String()
+	}
+	resamplerLabel := widget.NewLabel("Resampling:")
+	resamplerSelect := widget.NewSelect(resamplerNames, func(value string) {
+		if r, err := imageprocessing.ParseResampler(value); err == nil {
+			resampler = r
+		}
+		preview()
+	})
+	resamplerSelect.SetSelected(resampler.String())
+	pixelArtCheck := widget.NewCheck("Pixel Art (one stitch per native pixel)", func(checked bool) {
+		pixelArt = checked
+		if checked {
+			resamplerSelect.Disable()
+		} else {
+			resamplerSelect.Enable()
+		}
+		updateSizeLabel(sizeLabel)
+		preview()
+	})
+
+	// Image adjustments, previewed as each control is released
+	cropLabel := widget.NewLabel("Crop:")
+	cropEntry := widget.NewEntry()
+	cropEntry.SetPlaceHolder("x,y,width,height")
+	cropEntry.OnChanged = func(value string) {
+		if strings.TrimSpace(value) == "" {
+			preprocessing.Crop = image.Rectangle{}
+		} else {
+			crop, err := imageprocessing.ParseCrop(value)
+			if err != nil {
+				previewLabel.SetText(err.Error())
+				return
+			}
+			preprocessing.Crop = crop
+		}
+		updateSizeLabel(sizeLabel)
+		preview()
+	}
+
+	rotationNames := make([]string, len(imageprocessing.Rotations))
+	for i, r := range imageprocessing.Rotations {
+		rotationNames[i] = r.String()
+	}
+	rotationLabel := widget.NewLabel("Rotate:")
+	rotationSelect := widget.NewSelect(rotationNames, func(value string) {
+		if r, err := imageprocessing.ParseRotation(value); err == nil {
+			preprocessing.Rotation = r
+		}
+		updateSizeLabel(sizeLabel)
+		preview()
+	})
+	rotationSelect.SetSelected(preprocessing.Rotation.String())
+	flipHorizontalCheck := widget.NewCheck("Flip Horizontally", func(checked bool) {
+		preprocessing.FlipHorizontal = checked
+		preview()
+	})
+	flipVerticalCheck := widget.NewCheck("Flip Vertically", func(checked bool) {
+		preprocessing.FlipVertical = checked
+		preview()
+	})
+
+	brightnessSlider, brightnessLabel := getAdjustmentSlider(-100, 100, 0, preview, func(v float64) string {
+		preprocessing.Brightness = v / 100
+		return fmt.Sprintf("Brightness: %+.0f%%", v)
+	})
+	contrastSlider, contrastLabel := getAdjustmentSlider(-100, 100, 0, preview, func(v float64) string {
+		preprocessing.Contrast = v / 100
+		return fmt.Sprintf("Contrast: %+.0f%%", v)
+	})
+	saturationSlider, saturationLabel := getAdjustmentSlider(-100, 100, 0, preview, func(v float64) string {
+		preprocessing.Saturation = v / 100
+		return fmt.Sprintf("Saturation: %+.0f%%", v)
+	})
+	gammaSlider, gammaLabel := getAdjustmentSlider(0.2, 3, 1, preview, func(v float64) string {
+		preprocessing.Gamma = v
+		return fmt.Sprintf("Gamma: %.2f", v)
+	})
+	gammaSlider.Step = 0.05
+	posterizeSlider, posterizeLabel := getAdjustmentSlider(1, 16, 1, preview, func(v float64) string {
+		preprocessing.Posterize = int(v)
+		if v < 2 {
+			return "Posterize: off"
+		}
+		return "Posterize: " + strconv.Itoa(int(v)) + " levels"
+	})
+	sharpenSlider, sharpenLabel := getAdjustmentSlider(0, 300, 0, preview, func(v float64) string {
+		preprocessing.SharpenAmount = v / 100
+		if v == 0 {
+			return "Sharpen: off"
+		}
+		return "Sharpen: " + strconv.Itoa(int(v)) + "%"
+	})
+
+	// Thread estimates
+	strandsLabel := widget.NewLabel("Strands:")
+	strandsSelect := widget.NewSelect([]string{"1", "2", "3", "4", "5", "6"}, func(value string) {
+		if n, err := strconv.Atoi(value); err == nil {
+			threadMaterials.Strands = n
+		}
+		if currentPattern != nil {
+			showLegend(legendContainer)
+		}
+	})
+	strandsSelect.SetSelected(strconv.Itoa(threadMaterials.Strands))
+	pricesButton := getPricesButton(myWindow, legendContainer)
+
+	// Stash
+	stashLabel := widget.NewLabel("No stash loaded")
+	stashOnlyCheck := widget.NewCheck("Only use stash threads", func(checked bool) {
+		stashOnly = checked
+	})
+	stashButton := getStashButton(myWindow, stashLabel)
+	shoppingButton := getShoppingListButton(myWindow)
+
+	// Brand conversion
+	convertSelect := widget.NewSelect(threadRegistry.Brands(), nil)
+	convertSelect.PlaceHolder = "Convert to brand"
+	convertButton := getConvertButton(convertSelect, myWindow, imageCanvas, renderer, legendContainer)
+	// Choosing and converting brands needs a second library to choose from
+	setVisible(len(threadRegistry.Brands()) > 1, brandLabel, brandSelect, convertSelect, convertButton)
+	libraryButton := getLibraryButton(myWindow, func() {
+		brands := threadRegistry.Brands()
+		brandSelect.Options = brands
+		brandSelect.Refresh()
+		convertSelect.Options = brands
+		convertSelect.Refresh()
+		setVisible(len(brands) > 1, brandLabel, brandSelect, convertSelect, convertButton)
+	})
+
+	uploadButton, resizeButton, generateButton := getUploadAndGenerateButtons(sizeLabel, numColorsSlider, blendsSlider, ditherStrengthSlider, minRegionSlider, myWindow, imageCanvas, renderer, legendContainer, titleEntry, authorEntry)
+	savePatternButton, openPatternButton, exportPDFButton, exportSVGButton, exportOXSButton := getPatternButtons(myWindow, imageCanvas, renderer, customFont, legendContainer, titleEntry, authorEntry)
 
 	myWindow.SetContent(container.NewScroll(container.NewVBox(
 		label,
+		container.NewHBox(sizeByLabel, sizeBySelect, fitLabel, fitSelect),
 		heightLabel,
 		heightSlider,
+		widthLabel,
+		widthSlider,
+		maxStitchesLabel,
+		maxStitchesSlider,
+		container.NewHBox(fabricLabel, container.NewGridWrap(fyne.NewSize(260, fabricSelect.MinSize().Height), fabricSelect), finishedHeightLabel, container.NewGridWrap(fyne.NewSize(100, finishedHeightEntry.MinSize().Height), finishedHeightEntry), unitSelect),
+		sizeLabel,
+		container.NewHBox(resamplerLabel, resamplerSelect, pixelArtCheck),
 		numColorsLabel,
 		numColorsSlider,
+		blendsLabel,
+		blendsSlider,
+		container.NewHBox(brandLabel, brandSelect, metricLabel, metricSelect, methodLabel, methodSelect, ditherLabel, ditherSelect, symbolLabel, symbolSelect),
+		ditherStrengthLabel,
+		ditherStrengthSlider,
+		minRegionLabel,
+		minRegionSlider,
+		opacityLabel,
+		opacitySlider,
+		backgroundCheck,
+		toleranceLabel,
+		toleranceSlider,
+		container.NewHBox(cropLabel, container.NewGridWrap(fyne.NewSize(160, cropEntry.MinSize().Height), cropEntry), rotationLabel, rotationSelect, flipHorizontalCheck, flipVerticalCheck),
+		container.NewGridWithColumns(3,
+			brightnessLabel, contrastLabel, saturationLabel,
+			brightnessSlider, contrastSlider, saturationSlider,
+			gammaLabel, posterizeLabel, sharpenLabel,
+			gammaSlider, posterizeSlider, sharpenSlider,
+		),
+		previewLabel,
 		gridDownloadChoice,
 		uploadButton,
 		resizeButton,
 		generateButton,
+		container.NewGridWithColumns(2, titleEntry, authorEntry),
+		container.NewHBox(strandsLabel, strandsSelect, pricesButton, libraryButton, convertSelect, convertButton),
+		container.NewHBox(stashButton, stashLabel, stashOnlyCheck, shoppingButton),
+		container.NewHBox(savePatternButton, openPatternButton, exportPDFButton, exportSVGButton, exportOXSButton),
 		imageCanvas,
 		legendContainer,
 	)))
 
 	myWindow.Resize(fyne.NewSize(1400, 800))
+	if registryErr != nil {
+		dialog.ShowError(registryErr, myWindow)
+	}
 	myWindow.ShowAndRun()
 }
 
-func getUploadAndGenerateButtons(heightSlider *widget.Slider, numColorsSlider *widget.Slider, myWindow fyne.Window, imageCanvas *canvas.Image, customFont []byte, legendContainer *fyne.Container) (fyne.CanvasObject, fyne.CanvasObject, fyne.CanvasObject) {
+func getUploadAndGenerateButtons(sizeLabel *widget.Label, numColorsSlider *widget.Slider, blendsSlider *widget.Slider, ditherStrengthSlider *widget.Slider, minRegionSlider *widget.Slider, myWindow fyne.Window, imageCanvas *canvas.Image, renderer *render.Renderer, legendContainer *fyne.Container, titleEntry *widget.Entry, authorEntry *widget.Entry) (fyne.CanvasObject, fyne.CanvasObject, fyne.CanvasObject) {
 	currentDir, _ := os.Getwd()
 	curUri := storage.NewFileURI(currentDir)
 	uri, _ := storage.ListerForURI(curUri)
@@ -176,26 +654,32 @@ func getUploadAndGenerateButtons(heightSlider *widget.Slider, numColorsSlider *w
 
 			fmt.Println("Selected file:", reader.URI().Path())
 			imagePath := reader.URI().Path()
-			imageFile, err := os.Open(imagePath)
+			imageBytes, err := os.ReadFile(imagePath)
 			if err != nil {
 				dialog.ShowError(err, myWindow)
 				return
 			}
 
-			decodedImg, _, err := image.Decode(imageFile)
+			decodedImg, _, err := image.Decode(bytes.NewReader(imageBytes))
 			if err != nil {
 				dialog.ShowError(err, myWindow)
 				return
 			}
 
 			currentImage = decodedImg
+			currentImageHash, _ = common.HashSource(bytes.NewReader(imageBytes))
+			updateSizeLabel(sizeLabel)
 
 			// Process image based on height input
-			processedImage := imageprocessing.ResizeImage(currentImage, int(heightSlider.Value))
+			processedImage, _, err := resizeForChart()
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
 
 			// Display image on canvas
 			colorGrid := imageprocessing.GenerateColorGrid(processedImage, []common.ThreadColor{}, false)
-			gridImage := generateImageFromGrid(colorGrid, false, false, customFont)
+			gridImage := renderer.WithStyle(render.StyleFilled).Render(colorGrid)
 
 			imageCanvas.Image = gridImage
 			imageCanvas.Refresh()
@@ -213,11 +697,15 @@ func getUploadAndGenerateButtons(heightSlider *widget.Slider, numColorsSlider *w
 		}
 
 		// Resize the image
-		resizedImage := imageprocessing.ResizeImage(currentImage, int(heightSlider.Value))
+		resizedImage, _, err := resizeForChart()
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
 
 		// Display image on canvas
 		colorGrid := imageprocessing.GenerateColorGrid(resizedImage, []common.ThreadColor{}, false)
-		gridImage := generateImageFromGrid(colorGrid, showSymbol, useStitch, customFont)
+		gridImage := renderer.WithStyle(chartStyle).Render(colorGrid)
 
 		imageCanvas.Image = gridImage
 		imageCanvas.Refresh()
@@ -225,7 +713,6 @@ func getUploadAndGenerateButtons(heightSlider *widget.Slider, numColorsSlider *w
 
 	// Generate
 	generateButton := widget.NewButton("Generate", func() {
-		imgHeight := heightSlider.Value
 		numColors := numColorsSlider.Value
 
 		if currentImage == nil {
@@ -233,47 +720,641 @@ func getUploadAndGenerateButtons(heightSlider *widget.Slider, numColorsSlider *w
 			return
 		}
 
-		resizedImg := imageprocessing.ResizeImage(currentImage, int(imgHeight))
-		threadColors, err := imageprocessing.LoadThreadColors("assets/thread_colors.txt")
+		resizedImg, _, err := resizeForChart()
 		if err != nil {
-			dialog.ShowError(fmt.Errorf("Failed to load thread colors"), myWindow)
+			dialog.ShowError(err, myWindow)
 			return
 		}
+		library, err := threadRegistry.Library(threadBrand)
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		threadColors := library.Threads
+		if stashOnly && threadStash != nil {
+			threadColors = threadStash.Filter(threadColors)
+			if len(threadColors) == 0 {
+				dialog.ShowError(fmt.Errorf("The stash has no %s threads", library.Brand), myWindow)
+				return
+			}
+		}
+
+		threadPalette := imageprocessing.GetPartialPalette(resizedImg, threadColors, imageprocessing.PaletteOptions{
+			NumColors: int(numColors),
+			Method:    quantizeMethod,
+			Metric:    colorMetric,
+			Blends:    int(blendsSlider.Value),
+		})
+		reducedImg := imageprocessing.ReduceColors(resizedImg, threadPalette, imageprocessing.ReduceOptions{
+			Metric:         colorMetric,
+			Dither:         ditherMode,
+			DitherStrength: ditherStrengthSlider.Value,
+		})
+
+		var cleanupReport imageprocessing.CleanupReport
+		if minRegionSize := int(minRegionSlider.Value); minRegionSize > 1 {
+			reducedImg, cleanupReport = imageprocessing.RemoveConfetti(reducedImg, minRegionSize, colorMetric)
+		}
 
-		threadPalette = imageprocessing.GetPartialPalette(resizedImg, threadColors, int(numColors))
-		reducedImg := imageprocessing.ReduceColors(resizedImg, threadPalette)
+		pattern := common.NewPattern(imageprocessing.GenerateColorGrid(reducedImg, threadPalette, true))
+		if err := symbols.AssignForFont(pattern, symbolList, renderer.Font); err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		currentPattern = pattern
+		currentPattern.Metadata = common.PatternMetadata{
+			Title:           titleEntry.Text,
+			Author:          authorEntry.Text,
+			FabricCount:     int(math.Round(fabric.StitchesPerInch())),
+			SourceImageHash: currentImageHash,
+		}
 
 		// Display the resized and color-reduced image on canvas
-		colorGrid := imageprocessing.GenerateColorGrid(reducedImg, threadColors, true)
-		gridImage := generateImageFromGrid(colorGrid, showSymbol, useStitch, customFont)
+		colorGrid := currentPattern.Grid()
+		gridImage := renderer.WithStyle(chartStyle).Render(colorGrid)
 		updateGrid(colorGrid)
 
 		imageCanvas.Image = gridImage
 		imageCanvas.Refresh()
 
 		// Update and show the legend
-		legend := getLegend()
-		legendContainer.Objects = []fyne.CanvasObject{legend}
-		legendContainer.Show()
-		legendContainer.Refresh()
+		showLegend(legendContainer)
 
 		// Save the generated images
-		saveGeneratedImages(resizedImg, threadColors, customFont, myWindow)
+		if err := renderer.SaveCharts("output", colorGrid, render.Styles); err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		if err := export.SaveLegendCSV(filepath.Join("output", "legend.csv"), currentPattern, legendMaterials()); err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
 
-		dialog.ShowInformation("Success", "Image processed and saved successfully", myWindow)
+		message := "Image processed and saved successfully"
+		if cleanupReport.RegionsMerged > 0 {
+			message += fmt.Sprintf("\nConfetti cleanup merged %d regions (%d stitches changed)", cleanupReport.RegionsMerged, cleanupReport.CellsChanged)
+		}
+		dialog.ShowInformation("Success", message, myWindow)
 	})
 
 	return uploadButton, resizeButton, generateButton
 }
 
+// resizeOptions returns how the image is scaled to the chart, from the
+// size controls. A finished height replaces the height slider's value.
+func resizeOptions() imageprocessing.ResizeOptions {
+	opts := imageprocessing.ResizeOptions{
+		Fit:          fitMode,
+		StitchAspect: fabric.StitchAspect(),
+		Resampler:    resampler,
+	}
+	height := chartHeight
+	if finishedHeight > 0 {
+		height = materials.StitchesDown(finishedHeight, sizeUnit, fabric)
+	}
+	switch sizeBy {
+	case sizeByWidth:
+		opts.Width = chartWidth
+	case sizeByBoth:
+		opts.Width, opts.Height = chartWidth, height
+	case sizeByCount:
+		opts.MaxStitches = maxStitches
+	default:
+		opts.Height = height
+	}
+	return opts
+}
+
+// setVisible shows or hides the controls.
+func setVisible(visible bool, objects ...fyne.CanvasObject) {
+	for _, o := range objects {
+		if visible {
+			o.Show()
+		} else {
+			o.Hide()
+		}
+	}
+}
+
+// updateSizeLabel shows the chart's stitch count, finished size and the
+// fabric to cut for the loaded image.
+func updateSizeLabel(sizeLabel *widget.Label) {
+	if currentImage == nil {
+		sizeLabel.SetText("Finished size: load an image")
+		return
+	}
+	width, height := chartSize()
+	sizeLabel.SetText(materials.SizeSummary(width, height, fabric, sizeUnit))
+}
+
+// chartSize returns the chart's width and height in stitches: one stitch
+// per native pixel in pixel art mode, otherwise the prepared image scaled
+// as resizeOptions says.
+func chartSize() (int, int) {
+	width, height := sourceSize()
+	if pixelArt {
+		return width, height
+	}
+	return imageprocessing.ChartSize(image.Rect(0, 0, width, height), resizeOptions())
+}
+
+// showLegend replaces the legend with one for the current pattern.
+func showLegend(legendContainer *fyne.Container) {
+	legend := getLegend()
+	legendContainer.Objects = []fyne.CanvasObject{legend}
+	legendContainer.Show()
+	legendContainer.Refresh()
+}
+
+// getPatternButtons returns buttons that save the current pattern to JSON,
+// reopen a saved one or an OXS chart and export it as a printable PDF, an
+// SVG in the selected chart style or OXS.
+func getPatternButtons(myWindow fyne.Window, imageCanvas *canvas.Image, renderer *render.Renderer, customFont []byte, legendContainer *fyne.Container, titleEntry *widget.Entry, authorEntry *widget.Entry) (fyne.CanvasObject, fyne.CanvasObject, fyne.CanvasObject, fyne.CanvasObject, fyne.CanvasObject) {
+	saveButton := widget.NewButton("Save Pattern", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+		currentPattern.Metadata.Title = titleEntry.Text
+		currentPattern.Metadata.Author = authorEntry.Text
+
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			if err := currentPattern.Save(writer); err != nil {
+				dialog.ShowError(err, myWindow)
+			}
+		}, myWindow)
+		fileDialog.SetFileName("pattern.json")
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
+		fileDialog.Show()
+	})
+
+	openButton := widget.NewButton("Open Pattern", func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			var pattern *common.Pattern
+			if strings.EqualFold(reader.URI().Extension(), ".oxs") {
+				pattern, err = export.ReadOXS(reader)
+			} else {
+				pattern, err = common.LoadPattern(reader)
+			}
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			currentPattern = pattern
+			titleEntry.SetText(pattern.Metadata.Title)
+			authorEntry.SetText(pattern.Metadata.Author)
+
+			colorGrid := pattern.Grid()
+			imageCanvas.Image = renderer.WithStyle(chartStyle).Render(colorGrid)
+			imageCanvas.Refresh()
+			updateGrid(colorGrid)
+			showLegend(legendContainer)
+		}, myWindow)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".json", ".oxs"}))
+		fileDialog.Show()
+	})
+
+	exportPDFButton := widget.NewButton("Export PDF", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+		currentPattern.Metadata.Title = titleEntry.Text
+		currentPattern.Metadata.Author = authorEntry.Text
+
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			if err := export.WritePDF(writer, currentPattern, export.PDFOptions{Font: customFont, FabricCount: currentPattern.Metadata.FabricCount, Materials: legendMaterials()}); err != nil {
+				dialog.ShowError(err, myWindow)
+			}
+		}, myWindow)
+		fileDialog.SetFileName("chart.pdf")
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".pdf"}))
+		fileDialog.Show()
+	})
+
+	exportSVGButton := widget.NewButton("Export SVG", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			if err := export.WriteSVG(writer, currentPattern, export.SVGOptions{Style: chartStyle}); err != nil {
+				dialog.ShowError(err, myWindow)
+			}
+		}, myWindow)
+		fileDialog.SetFileName("chart.svg")
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".svg"}))
+		fileDialog.Show()
+	})
+
+	exportOXSButton := widget.NewButton("Export OXS", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+		currentPattern.Metadata.Title = titleEntry.Text
+		currentPattern.Metadata.Author = authorEntry.Text
+
+		fileDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			if err := export.WriteOXS(writer, currentPattern); err != nil {
+				dialog.ShowError(err, myWindow)
+			}
+		}, myWindow)
+		fileDialog.SetFileName("chart.oxs")
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".oxs"}))
+		fileDialog.Show()
+	})
+
+	return saveButton, openButton, exportPDFButton, exportSVGButton, exportOXSButton
+}
+
+// orientedSource returns the current image cropped, rotated and flipped as
+// set in preprocessing, reduced to one pixel per native pixel in pixel art
+// mode.
+func orientedSource() (image.Image, error) {
+	source, err := imageprocessing.Orient(currentImage, preprocessing)
+	if err != nil {
+		return nil, err
+	}
+	if pixelArt {
+		source = imageprocessing.SamplePixelGrid(source, imageprocessing.DetectPixelGrid(source))
+	}
+	return source, nil
+}
+
+// sourceSize returns the size of the image orientedSource returns, without
+// preparing it when the size is known from the crop and rotation alone.
+func sourceSize() (int, int) {
+	if pixelArt {
+		if source, err := orientedSource(); err == nil {
+			return source.Bounds().Dx(), source.Bounds().Dy()
+		}
+	}
+	return imageprocessing.OrientedSize(currentImage.Bounds(), preprocessing)
+}
+
+// chartSource returns the oriented current image with its background
+// removed when that is enabled.
+func chartSource() (image.Image, imageprocessing.BackgroundReport, error) {
+	source, err := orientedSource()
+	if err != nil {
+		return nil, imageprocessing.BackgroundReport{}, err
+	}
+	if !removeBackground {
+		return source, imageprocessing.BackgroundReport{}, nil
+	}
+	cleared, report := imageprocessing.RemoveBackground(source, backgroundTolerance)
+	return cleared, report, nil
+}
+
+// resizeForChart resizes the prepared current image to the chart height
+// with the chosen resampler, unless it is pixel art already at one pixel
+// per stitch, applies the image adjustments and clears pixels too
+// transparent to stitch.
+func resizeForChart() (image.Image, imageprocessing.BackgroundReport, error) {
+	source, report, err := chartSource()
+	if err != nil {
+		return nil, report, err
+	}
+	resized := source
+	if !pixelArt {
+		resized = imageprocessing.ResizeImage(source, resizeOptions())
+	}
+	resized = imageprocessing.Adjust(resized, preprocessing)
+	return imageprocessing.ClearTransparent(resized, alphaThreshold), report, nil
+}
+
+// previewChart shows the resized and adjusted image as it will be charted,
+// with removed background left as empty cells, and describes what was
+// removed or why the image could not be prepared.
+func previewChart(imageCanvas *canvas.Image, renderer *render.Renderer, previewLabel *widget.Label) {
+	if currentImage == nil {
+		previewLabel.SetText("")
+		return
+	}
+
+	resized, report, err := resizeForChart()
+	if err != nil {
+		previewLabel.SetText(err.Error())
+		return
+	}
+	imageCanvas.Image = renderer.WithStyle(render.StyleFilled).Render(imageprocessing.GenerateColorGrid(resized, nil, false))
+	imageCanvas.Refresh()
+
+	switch {
+	case !removeBackground:
+		previewLabel.SetText("")
+	case !report.Found:
+		previewLabel.SetText("No uniform background found along the edges")
+	default:
+		width, height := sourceSize()
+		share := 100 * float64(report.PixelsCleared) / float64(width*height)
+		previewLabel.SetText(fmt.Sprintf("Removed %.0f%% of the image around #%02x%02x%02x", share, report.Color.R, report.Color.G, report.Color.B))
+	}
+}
+
+// getAdjustmentSlider returns a slider from minValue to maxValue starting
+// at value, and a label showing describe's text for the slider's value.
+// describe also stores the value in preprocessing; preview is called once
+// the slider is released.
+func getAdjustmentSlider(minValue, maxValue, value float64, preview func(), describe func(float64) string) (*widget.Slider, *widget.Label) {
+	data := binding.NewFloat()
+	data.Set(value)
+	slider := widget.NewSliderWithData(minValue, maxValue, data)
+	slider.OnChangeEnded = func(float64) {
+		preview()
+	}
+
+	label := widget.NewLabelWithData(binding.NewString())
+	data.AddListener(binding.NewDataListener(func() {
+		floatVal, _ := data.Get()
+		label.SetText(describe(floatVal))
+	}))
+	return slider, label
+}
+
+// legendMaterials returns the thread estimate options for the current
+// pattern.
+func legendMaterials() materials.Options {
+	opts := threadMaterials
+	if currentPattern != nil {
+		opts.FabricCount = currentPattern.Metadata.FabricCount
+	}
+	return opts
+}
+
+// getConvertButton returns a button that converts the current pattern to
+// the nearest threads of the brand chosen in convertSelect and shows the
+// conversion report.
+func getConvertButton(convertSelect *widget.Select, myWindow fyne.Window, imageCanvas *canvas.Image, renderer *render.Renderer, legendContainer *fyne.Container) fyne.CanvasObject {
+	return widget.NewButton("Convert", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+		target, err := threadRegistry.Library(convertSelect.Selected)
+		if err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+
+		converted, report := threads.Convert(currentPattern, target, colorMetric, threads.DefaultMismatchThreshold)
+		currentPattern = converted
+
+		colorGrid := converted.Grid()
+		imageCanvas.Image = renderer.WithStyle(chartStyle).Render(colorGrid)
+		imageCanvas.Refresh()
+		updateGrid(colorGrid)
+		showLegend(legendContainer)
+
+		var text strings.Builder
+		report.WriteText(&text)
+		reportLabel := widget.NewLabel(text.String())
+		reportLabel.TextStyle = fyne.TextStyle{Monospace: true}
+		reportScroll := container.NewScroll(reportLabel)
+		reportScroll.SetMinSize(fyne.NewSize(800, 400))
+		dialog.ShowCustom("Converted to "+target.Brand, "Close", reportScroll, myWindow)
+	})
+}
+
+// getStashButton returns a button that loads a stash file of
+// "<thread>,<skeins>" lines.
+func getStashButton(myWindow fyne.Window, stashLabel *widget.Label) fyne.CanvasObject {
+	return widget.NewButton("Load Stash", func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			stash, err := materials.ReadStash(reader)
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			threadStash = stash
+			stashLabel.SetText(fmt.Sprintf("Stash: %d threads", stash.Len()))
+		}, myWindow)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv", ".txt", ".tsv"}))
+		fileDialog.Show()
+	})
+}
+
+// getLibraryButton returns a button that loads another brand's palette
+// file, named by the user, into the thread registry and then calls
+// onLoad.
+func getLibraryButton(myWindow fyne.Window, onLoad func()) fyne.CanvasObject {
+	return widget.NewButton("Load Library…", func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			path := reader.URI().Path()
+			reader.Close()
+
+			brandEntry := widget.NewEntry()
+			brandEntry.SetText(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+			items := []*widget.FormItem{widget.NewFormItem("Brand", brandEntry)}
+			dialog.ShowForm("Load Library", "Load", "Cancel", items, func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+				brand := strings.TrimSpace(brandEntry.Text)
+				if brand == "" {
+					dialog.ShowError(fmt.Errorf("No brand name given"), myWindow)
+					return
+				}
+				lib, err := threads.LoadLibrary(brand, path)
+				if err != nil {
+					dialog.ShowError(err, myWindow)
+					return
+				}
+				threadRegistry.Register(lib)
+				onLoad()
+			}, myWindow)
+		}, myWindow)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".txt", ".tsv", ".csv", ".json"}))
+		fileDialog.Show()
+	})
+}
+
+// getShoppingListButton returns a button that shows how much of the stash
+// the current pattern uses and which threads have to be bought.
+func getShoppingListButton(myWindow fyne.Window) fyne.CanvasObject {
+	return widget.NewButton("Shopping List", func() {
+		if currentPattern == nil {
+			dialog.ShowError(fmt.Errorf("No pattern generated"), myWindow)
+			return
+		}
+
+		opts := legendMaterials()
+		report := materials.CompareStash(materials.EstimatePattern(currentPattern, opts), threadStash, opts.Prices)
+		var text strings.Builder
+		report.WriteText(&text)
+		reportLabel := widget.NewLabel(text.String())
+		reportLabel.TextStyle = fyne.TextStyle{Monospace: true}
+		reportScroll := container.NewScroll(reportLabel)
+		reportScroll.SetMinSize(fyne.NewSize(800, 400))
+		dialog.ShowCustom("Shopping List", "Close", reportScroll, myWindow)
+	})
+}
+
+// getPricesButton returns a button that loads a price table of
+// "<thread id>,<price per skein>" lines for the legend's cost column.
+func getPricesButton(myWindow fyne.Window, legendContainer *fyne.Container) fyne.CanvasObject {
+	return widget.NewButton("Load Prices", func() {
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			prices, err := materials.ReadPrices(reader)
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			threadMaterials.Prices = prices
+			if currentPattern != nil {
+				showLegend(legendContainer)
+			}
+		}, myWindow)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv", ".txt", ".tsv"}))
+		fileDialog.Show()
+	})
+}
+
+// getSymbolSelect returns a select of the symbol sets. Choosing Custom asks
+// for a symbol list file. The current pattern is given the new symbols.
+func getSymbolSelect(myWindow fyne.Window, imageCanvas *canvas.Image, renderer *render.Renderer, legendContainer *fyne.Container) fyne.CanvasObject {
+	setNames := make([]string, len(symbols.Sets))
+	for i, set := range symbols.Sets {
+		setNames[i] = set.String()
+	}
+
+	applySymbols := func(list []string) {
+		if currentPattern != nil {
+			if err := symbols.AssignForFont(currentPattern, list, renderer.Font); err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			colorGrid := currentPattern.Grid()
+			imageCanvas.Image = renderer.WithStyle(chartStyle).Render(colorGrid)
+			imageCanvas.Refresh()
+			updateGrid(colorGrid)
+			showLegend(legendContainer)
+		}
+		symbolList = list
+	}
+
+	symbolSelect := widget.NewSelect(setNames, nil)
+	symbolSelect.SetSelected(symbols.SetSymbols.String())
+	symbolSelect.OnChanged = func(value string) {
+		set, err := symbols.ParseSet(value)
+		if err != nil {
+			return
+		}
+		if set != symbols.SetCustom {
+			applySymbols(set.Symbols())
+			return
+		}
+
+		fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			list, err := symbols.ReadCustom(reader)
+			if err != nil {
+				dialog.ShowError(err, myWindow)
+				return
+			}
+			applySymbols(list)
+		}, myWindow)
+		fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".txt"}))
+		fileDialog.Show()
+	}
+	return symbolSelect
+}
+
 func getLegend() fyne.CanvasObject {
+	var estimate materials.Estimate
+	if currentPattern != nil {
+		estimate = materials.EstimatePattern(currentPattern, legendMaterials())
+	}
+	const numCols = 8
+
 	legend := widget.NewTableWithHeaders(
 		func() (int, int) {
-			// Returning the number of rows and columns
-			if threadPalette == nil {
-				return 0, 4
+			// Returning the number of rows and columns, with a final
+			// row for the totals
+			if currentPattern == nil {
+				return 0, numCols
 			}
-			return len(threadPalette), 4
+			return len(estimate.Threads) + 1, numCols
 		},
 		func() fyne.CanvasObject {
 			// Create a new label for each cell
@@ -284,22 +1365,57 @@ func getLegend() fyne.CanvasObject {
 			i := o.(*fyne.Container).Objects[1].(*canvas.Rectangle)
 			l.Show()
 			i.Hide()
+			l.SetText("")
 
-			if threadPalette != nil && id.Row < len(threadPalette) {
+			if currentPattern == nil {
+				return
+			}
+			if id.Row == len(estimate.Threads) {
+				switch id.Col {
+				case 2:
+					l.SetText("Total")
+				case 4:
+					l.SetText(strconv.Itoa(estimate.Stitches))
+				case 5:
+					l.SetText(fmt.Sprintf("%.1f m", estimate.Length))
+				case 6:
+					l.SetText(strconv.Itoa(estimate.SkeinsToBuy))
+				case 7:
+					if estimate.Priced {
+						l.SetText(fmt.Sprintf("%.2f", estimate.Cost))
+					}
+				}
+				return
+			}
+			if id.Row < len(estimate.Threads) {
+				usage := estimate.Threads[id.Row]
+				thread := usage.Thread
 				switch id.Col {
 				case 0:
-					l.SetText(threadPalette[id.Row].Symbol)
+					l.SetText(thread.Symbol)
 				case 1:
-					l.SetText("DMC " + strconv.Itoa(threadPalette[id.Row].ID))
+					l.SetText(thread.Code())
 				case 2:
-					l.SetText(threadPalette[id.Row].Name)
+					l.SetText(thread.Name)
 				case 3:
 					l.Hide()
-					color := threadPalette[id.Row].Color
+					color := thread.Color
 					color.A = 255
 					i.FillColor = color
 					i.SetMinSize(fyne.NewSize(20, 20))
 					i.Show()
+				case 4:
+					l.SetText(strconv.Itoa(usage.Stitches))
+				case 5:
+					l.SetText(fmt.Sprintf("%.1f m", usage.Length))
+				case 6:
+					l.SetText(fmt.Sprintf("%d (%.2f)", usage.SkeinsToBuy, usage.Skeins))
+				case 7:
+					if usage.Priced {
+						l.SetText(fmt.Sprintf("%.2f", usage.Cost))
+					} else if estimate.Priced {
+						l.SetText("?")
+					}
 				}
 			}
 		})
@@ -307,7 +1423,11 @@ func getLegend() fyne.CanvasObject {
 	// Set column widths
 	legend.SetColumnWidth(0, 80)
 	legend.SetColumnWidth(1, 120)
-	legend.SetColumnWidth(2, 450)
+	legend.SetColumnWidth(2, 300)
+	legend.SetColumnWidth(4, 90)
+	legend.SetColumnWidth(5, 90)
+	legend.SetColumnWidth(6, 100)
+	legend.SetColumnWidth(7, 80)
 
 	// Create header for the table
 	legend.CreateHeader = func() fyne.CanvasObject {
@@ -325,136 +1445,31 @@ func getLegend() fyne.CanvasObject {
 			label.SetText("Name")
 		case 3:
 			label.SetText("Color")
+		case 4:
+			label.SetText("Stitches")
+		case 5:
+			label.SetText("Length")
+		case 6:
+			label.SetText("Skeins")
+		case 7:
+			label.SetText("Cost")
 		}
 	}
 
 	// Scroll container to make table height adjustable
 	scrollContainer := container.NewScroll(legend)
-	scrollContainer.SetMinSize(fyne.NewSize(650, 300))
+	scrollContainer.SetMinSize(fyne.NewSize(950, 300))
 
 	return scrollContainer
 }
 
-func generateImageFromGrid(grid [][]common.ThreadColor, showSymbol bool,
-	useStitch bool, customFont []byte) image.Image {
-	numRows := len(grid)
-	numCols := len(grid[0])
-
-	cellSize := 20
-	imgWidth := numCols * cellSize
-	imgHeight := numRows * cellSize
-
-	img := image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight))
-
-	for row := 0; row < numRows; row++ {
-		for col := 0; col < numCols; col++ {
-			cell := grid[row][col]
-			x := col * cellSize
-			y := row * cellSize
-
-			cellColor := cell.Color
-			cellColor.A = 255
-
-			if useStitch {
-				stitchThickness := 3
-
-				for i := 0; i < cellSize; i++ {
-					for t := 0; t < stitchThickness; t++ {
-						img.Set(x+i, y+i+t, cellColor)            // top left diagonal
-						img.Set(x+i, y+cellSize-1-i-t, cellColor) // bottom left diagonal
-						img.Set(x+i+t, y+i, cellColor)            // top left diagonal (offset)
-						img.Set(x+i+t, y+cellSize-1-i, cellColor) // bottom left diagonal (offset)
-					}
-				}
-			} else {
-				// Fill with color
-				draw.Draw(img, image.Rect(x, y, x+cellSize, y+cellSize), &image.Uniform{cellColor}, image.Point{}, draw.Src)
-			}
-
-			if showSymbol {
-				fnt, _ := opentype.Parse(customFont)
-				face, _ := opentype.NewFace(fnt, &opentype.FaceOptions{
-					Size:    float64(cellSize),
-					DPI:     72,
-					Hinting: font.HintingFull,
-				})
-				defer face.Close()
-
-				fontColor := image.White
-				if (float32(cell.Color.R)*0.299 + float32(cell.Color.G)*0.587 + float32(cell.Color.B)*0.114) > 186 {
-					fontColor = image.Black
-				}
-
-				drawer := &font.Drawer{
-					Dst:  img,
-					Src:  fontColor,
-					Face: face,
-				}
-				drawer.Dot = fixed.Point26_6{
-					X: fixed.I(x + cellSize/4),
-					Y: fixed.I(y + cellSize - cellSize/4),
-				}
-				symbol := cell.Symbol
-				drawer.DrawString(symbol)
-			}
-
-			// Border around cell
-			borderColor := color.Black
-			borderThickness := 1
-			draw.Draw(img, image.Rect(x, y, x+cellSize, y+borderThickness), &image.Uniform{borderColor}, image.Point{}, draw.Src)
-			draw.Draw(img, image.Rect(x, y, x+borderThickness, y+cellSize), &image.Uniform{borderColor}, image.Point{}, draw.Src)
-			draw.Draw(img, image.Rect(x, y+cellSize-borderThickness, x+cellSize, y+cellSize), &image.Uniform{borderColor}, image.Point{}, draw.Src)
-			draw.Draw(img, image.Rect(x+cellSize-borderThickness, y, x+cellSize, y+cellSize), &image.Uniform{borderColor}, image.Point{}, draw.Src)
-		}
-	}
-
-	return img
-}
-
-func saveGeneratedImages(img image.Image, threadColors []common.ThreadColor, customFont []byte, myWindow fyne.Window) {
-	basePath := "output/"
-	err := os.MkdirAll(basePath, os.ModePerm)
-	if err != nil {
-		dialog.ShowError(fmt.Errorf("Failed to create output directory"), myWindow)
-		return
-	}
-
-	types := []struct {
-		showSymbol bool
-		useStitch  bool
-		name       string
-	}{
-		{true, false, "filled_color_and_symbol.jpg"},
-		{false, false, "filled_color.jpg"},
-		{false, true, "x_stitch.jpg"},
-	}
-
-	for _, t := range types {
-		colorGrid := imageprocessing.GenerateColorGrid(img, threadColors, true)
-		gridImage := generateImageFromGrid(colorGrid, t.showSymbol, t.useStitch, customFont)
-
-		saveImageToFile(gridImage, basePath+t.name)
-	}
-}
-
-func saveImageToFile(img image.Image, pathname string) {
-	file, err := os.Create(pathname)
-	if err != nil {
-		fmt.Println("Failed to save image:", err)
-		return
-	}
-	defer file.Close()
-
-	err = jpeg.Encode(file, img, nil)
-	if err != nil {
-		fmt.Println("Failed to encode image:", err)
-	}
-}
-
 func updateRectangleColor(row, col int, threadColor common.ThreadColor) {
 	if row >= 0 && row < len(rectangles) && col >= 0 && col < len(rectangles[row]) {
 		r, g, b, _ := threadColor.Color.RGBA()
 		rectangles[row][col].FillColor = color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+		if threadColor.IsEmpty() {
+			rectangles[row][col].FillColor = color.White
+		}
 		rectangles[row][col].Refresh() // Refresh to apply the color change
 	}
 }
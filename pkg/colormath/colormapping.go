@@ -14,7 +14,14 @@ func colorDistance(rImg, gImg, bImg uint32, rPal, gPal, bPal uint8) float64 {
 			(bImg-uint32(bPal))*(bImg-uint32(bPal))))
 }
 
-func NearestColor(originalColor color.Color, palette []common.ThreadColor) common.ThreadColor {
+// NearestColor returns the palette entry closest to originalColor under
+// metric. It converts the palette for every call; to match many colors,
+// build a ColorMatcher once for the palette instead.
+func NearestColor(originalColor color.Color, palette []common.ThreadColor, metric Metric) common.ThreadColor {
+	if metric != MetricRGB {
+		return NewLinearMatcher(palette, metric).Nearest(originalColor)
+	}
+
 	rImg, gImg, bImg, _ := originalColor.RGBA()
 	rImg, gImg, bImg = rImg>>8, gImg>>8, bImg>>8
 	minDistance := math.MaxFloat64
@@ -0,0 +1,195 @@
+package colormath
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Metric selects how the distance between two colors is measured.
+type Metric int
+
+const (
+	// MetricRGB is the Euclidean distance between 8-bit sRGB values.
+	MetricRGB Metric = iota
+	// MetricCIE76 is the Euclidean distance in CIE L*a*b*.
+	MetricCIE76
+	// MetricCIE94 is the CIE 1994 color difference (graphic arts weights).
+	MetricCIE94
+	// MetricCIEDE2000 is the CIE 2000 color difference.
+	MetricCIEDE2000
+)
+
+// Metrics lists every supported metric, in display order.
+var Metrics = []Metric{MetricRGB, MetricCIE76, MetricCIE94, MetricCIEDE2000}
+
+func (m Metric) String() string {
+	switch m {
+	case MetricRGB:
+		return "RGB"
+	case MetricCIE76:
+		return "CIE76"
+	case MetricCIE94:
+		return "CIE94"
+	case MetricCIEDE2000:
+		return "CIEDE2000"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric returns the metric whose name matches s, ignoring case.
+func ParseMetric(s string) (Metric, error) {
+	for _, m := range Metrics {
+		if strings.EqualFold(m.String(), s) {
+			return m, nil
+		}
+	}
+	return MetricRGB, fmt.Errorf("unknown color metric: %s", s)
+}
+
+// UsesLab reports whether the metric compares colors in CIE L*a*b*.
+func (m Metric) UsesLab() bool {
+	return m != MetricRGB
+}
+
+// LabDistance returns the distance between two Lab colors under the metric.
+// MetricRGB has no Lab form and falls back to CIE76.
+func (m Metric) LabDistance(x, y Lab) float64 {
+	switch m {
+	case MetricCIE94:
+		return CIE94(x, y)
+	case MetricCIEDE2000:
+		return CIEDE2000(x, y)
+	default:
+		return CIE76(x, y)
+	}
+}
+
+// lightnessBound returns a lower bound of the metric's distance between x
+// and y from their lightness alone, which is cheap enough to rule out most
+// palette entries before the full formula. The metrics add non-negative
+// chroma and hue terms to the lightness term; CIEDE2000's rotation term is
+// at most 2 in size, so its chroma and hue terms cannot sum below zero.
+func (m Metric) lightnessBound(x, y Lab) float64 {
+	dL := math.Abs(x.L - y.L)
+	if m != MetricCIEDE2000 {
+		return dL
+	}
+	lBar50 := ((x.L+y.L)/2 - 50) * ((x.L+y.L)/2 - 50)
+	return dL / (1 + 0.015*lBar50/math.Sqrt(20+lBar50))
+}
+
+// CIE76 returns the Euclidean distance between two Lab colors.
+func CIE76(x, y Lab) float64 {
+	dL, dA, dB := x.L-y.L, x.A-y.A, x.B-y.B
+	return math.Sqrt(dL*dL + dA*dA + dB*dB)
+}
+
+// CIE94 returns the CIE 1994 color difference using graphic arts weights,
+// with x as the reference color.
+func CIE94(x, y Lab) float64 {
+	const (
+		kL = 1.0
+		k1 = 0.045
+		k2 = 0.015
+	)
+
+	dL := x.L - y.L
+	c1 := math.Hypot(x.A, x.B)
+	c2 := math.Hypot(y.A, y.B)
+	dC := c1 - c2
+	dA, dB := x.A-y.A, x.B-y.B
+	dH2 := dA*dA + dB*dB - dC*dC
+	if dH2 < 0 {
+		dH2 = 0
+	}
+
+	sC := 1 + k1*c1
+	sH := 1 + k2*c1
+
+	l := dL / kL
+	c := dC / sC
+	return math.Sqrt(l*l + c*c + dH2/(sH*sH))
+}
+
+// CIEDE2000 returns the CIE 2000 color difference between two Lab colors.
+func CIEDE2000(x, y Lab) float64 {
+	const pow25to7 = 6103515625.0 // 25^7
+
+	c1 := math.Hypot(x.A, x.B)
+	c2 := math.Hypot(y.A, y.B)
+	cBar := (c1 + c2) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+
+	a1 := (1 + g) * x.A
+	a2 := (1 + g) * y.A
+	c1p := math.Hypot(a1, x.B)
+	c2p := math.Hypot(a2, y.B)
+	h1p := hueAngle(a1, x.B)
+	h2p := hueAngle(a2, y.B)
+
+	dLp := y.L - x.L
+	dCp := c2p - c1p
+
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dhp/2))
+
+	lBarP := (x.L + y.L) / 2
+	cBarP := (c1p + c2p) / 2
+
+	hBarP := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) > 180 {
+			if hBarP < 360 {
+				hBarP += 360
+			} else {
+				hBarP -= 360
+			}
+		}
+		hBarP /= 2
+	}
+
+	t := 1 -
+		0.17*math.Cos(radians(hBarP-30)) +
+		0.24*math.Cos(radians(2*hBarP)) +
+		0.32*math.Cos(radians(3*hBarP+6)) -
+		0.20*math.Cos(radians(4*hBarP-63))
+
+	dTheta := 30 * math.Exp(-((hBarP-275)/25)*((hBarP-275)/25))
+	cBarP7 := math.Pow(cBarP, 7)
+	rC := 2 * math.Sqrt(cBarP7/(cBarP7+pow25to7))
+	lBarP50 := (lBarP - 50) * (lBarP - 50)
+	sL := 1 + 0.015*lBarP50/math.Sqrt(20+lBarP50)
+	sC := 1 + 0.045*cBarP
+	sH := 1 + 0.015*cBarP*t
+	rT := -math.Sin(radians(2*dTheta)) * rC
+
+	l := dLp / sL
+	c := dCp / sC
+	h := dHp / sH
+	return math.Sqrt(l*l + c*c + h*h + rT*c*h)
+}
+
+func hueAngle(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,103 @@
+package colormath
+
+import (
+	"image/color"
+	"math"
+)
+
+// Lab is a color in the CIE L*a*b* space using the D65 reference white.
+type Lab struct {
+	L, A, B float64
+}
+
+// D65 reference white in XYZ, scaled so that Y = 1.
+const (
+	whiteX = 0.95047
+	whiteY = 1.00000
+	whiteZ = 1.08883
+)
+
+// srgbToLinear removes the sRGB gamma curve from an 8-bit channel value.
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+// RGBToLab converts an 8-bit sRGB color to CIE L*a*b*.
+func RGBToLab(r, g, b uint8) Lab {
+	rl, gl, bl := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+
+	x := 0.4124564*rl + 0.3575761*gl + 0.1804375*bl
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := 0.0193339*rl + 0.1191920*gl + 0.9503041*bl
+
+	fx, fy, fz := labF(x/whiteX), labF(y/whiteY), labF(z/whiteZ)
+
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+// ToLab converts any color.Color to CIE L*a*b*, ignoring alpha.
+func ToLab(c color.Color) Lab {
+	r, g, b, _ := c.RGBA()
+	return RGBToLab(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+}
+
+// linearToSRGB applies the sRGB gamma curve and clamps to an 8-bit channel.
+func linearToSRGB(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func labFInv(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta {
+		return t * t * t
+	}
+	return 3 * delta * delta * (t - 4.0/29.0)
+}
+
+// LabToRGB converts a CIE L*a*b* color back to 8-bit sRGB, clamping colors
+// that fall outside the sRGB gamut.
+func LabToRGB(lab Lab) color.RGBA {
+	fy := (lab.L + 16) / 116
+	fx := fy + lab.A/500
+	fz := fy - lab.B/200
+
+	x := whiteX * labFInv(fx)
+	y := whiteY * labFInv(fy)
+	z := whiteZ * labFInv(fz)
+
+	rl := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	gl := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	bl := 0.0556434*x - 0.2040259*y + 1.0572252*z
+
+	return color.RGBA{R: linearToSRGB(rl), G: linearToSRGB(gl), B: linearToSRGB(bl), A: 255}
+}
+
+// MixRGB returns the color two threads make when stitched together, as the
+// average of their colors in linear light. Alpha is taken from a.
+func MixRGB(a, b color.RGBA) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return linearToSRGB((srgbToLinear(x) + srgbToLinear(y)) / 2)
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: a.A}
+}
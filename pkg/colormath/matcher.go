@@ -0,0 +1,287 @@
+package colormath
+
+import (
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// ColorMatcher finds the palette thread closest to a color.
+type ColorMatcher interface {
+	Nearest(c color.Color) common.ThreadColor
+}
+
+// IndexMatcher is a ColorMatcher that also reports the palette index of the
+// closest thread, or -1 for an empty palette.
+type IndexMatcher interface {
+	ColorMatcher
+	NearestIndex(c color.Color) int
+}
+
+// NewMatcher returns the default matcher for a palette: NewIndexMatcher
+// wrapped in a cache keyed on RGB. Build it once per palette and reuse it
+// for every pixel.
+func NewMatcher(palette []common.ThreadColor, metric Metric) ColorMatcher {
+	return NewCachedMatcher(NewIndexMatcher(palette, metric))
+}
+
+// NewIndexMatcher returns the fastest matcher whose answers match a linear
+// scan: a KDTreeMatcher for RGB and CIE76, which are Euclidean in its space,
+// and a LinearMatcher for CIE94 and CIEDE2000, which it only approximates.
+// It is safe for concurrent use.
+func NewIndexMatcher(palette []common.ThreadColor, metric Metric) IndexMatcher {
+	if metric == MetricRGB || metric == MetricCIE76 {
+		return NewKDTreeMatcher(palette, metric)
+	}
+	return NewLinearMatcher(palette, metric)
+}
+
+// point maps a color into the space a metric searches in: raw RGB for
+// MetricRGB and Lab for the others.
+func point(c color.RGBA, metric Metric) [3]float64 {
+	if !metric.UsesLab() {
+		return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	}
+	lab := RGBToLab(c.R, c.G, c.B)
+	return [3]float64{lab.L, lab.A, lab.B}
+}
+
+func toRGBA(c color.Color) color.RGBA {
+	r, g, b, _ := c.RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+}
+
+// distance measures two points produced by point under the metric.
+func (m Metric) distance(x, y [3]float64) float64 {
+	if !m.UsesLab() {
+		return euclidean(x, y)
+	}
+	return m.LabDistance(Lab{x[0], x[1], x[2]}, Lab{y[0], y[1], y[2]})
+}
+
+// LinearMatcher compares a color against every palette entry. Palette
+// coordinates are converted once when the matcher is built, and entries
+// too far off in lightness to be closest are skipped without working out
+// the full metric, which keeps its answers exact.
+type LinearMatcher struct {
+	palette []common.ThreadColor
+	points  [][3]float64
+	metric  Metric
+}
+
+// NewLinearMatcher builds a LinearMatcher for the palette.
+func NewLinearMatcher(palette []common.ThreadColor, metric Metric) *LinearMatcher {
+	points := make([][3]float64, len(palette))
+	for i, tc := range palette {
+		points[i] = point(tc.Color, metric)
+	}
+	return &LinearMatcher{palette: palette, points: points, metric: metric}
+}
+
+func (m *LinearMatcher) Nearest(c color.Color) common.ThreadColor {
+	i := m.NearestIndex(c)
+	if i < 0 {
+		return common.ThreadColor{}
+	}
+	return m.palette[i]
+}
+
+// NearestIndex returns the palette index of the closest thread, or -1 for
+// an empty palette. It is safe for concurrent use.
+func (m *LinearMatcher) NearestIndex(c color.Color) int {
+	p := point(toRGBA(c), m.metric)
+	minDistance := math.MaxFloat64
+	nearest := -1
+
+	for i, q := range m.points {
+		if m.metric.UsesLab() && m.metric.lightnessBound(Lab{p[0], p[1], p[2]}, Lab{q[0], q[1], q[2]}) > minDistance {
+			continue
+		}
+		distance := m.metric.distance(p, q)
+		if distance < minDistance {
+			minDistance = distance
+			nearest = i
+		}
+	}
+
+	return nearest
+}
+
+// rerankCandidates is how many Euclidean neighbours are re-ranked by a
+// non-Euclidean metric.
+const rerankCandidates = 32
+
+type kdNode struct {
+	point       [3]float64
+	index       int
+	axis        int
+	left, right int
+}
+
+// KDTreeMatcher answers nearest-color queries with a 3-d tree over the
+// palette. RGB and CIE76 are Euclidean in the tree's space, so answers match
+// a linear scan exactly. For CIE94 and CIEDE2000 the closest Lab neighbours
+// are re-ranked by the metric, and the metric's best match can lie outside
+// that set: against the DMC palette this happens for about 0.3% of the RGB
+// cube with CIE94 and 5% with CIEDE2000. NewIndexMatcher picks an exact
+// matcher for those metrics.
+type KDTreeMatcher struct {
+	palette []common.ThreadColor
+	points  [][3]float64
+	nodes   []kdNode
+	root    int
+	metric  Metric
+}
+
+// NewKDTreeMatcher builds a KDTreeMatcher for the palette.
+func NewKDTreeMatcher(palette []common.ThreadColor, metric Metric) *KDTreeMatcher {
+	m := &KDTreeMatcher{palette: palette, metric: metric, root: -1}
+
+	indices := make([]int, len(palette))
+	points := make([][3]float64, len(palette))
+	for i, tc := range palette {
+		indices[i] = i
+		points[i] = point(tc.Color, metric)
+	}
+
+	m.points = points
+	m.nodes = make([]kdNode, 0, len(palette))
+	m.root = m.build(indices, points, 0)
+	return m
+}
+
+func (m *KDTreeMatcher) build(indices []int, points [][3]float64, depth int) int {
+	if len(indices) == 0 {
+		return -1
+	}
+
+	axis := depth % 3
+	sort.Slice(indices, func(i, j int) bool {
+		return points[indices[i]][axis] < points[indices[j]][axis]
+	})
+	mid := len(indices) / 2
+
+	m.nodes = append(m.nodes, kdNode{
+		point: points[indices[mid]],
+		index: indices[mid],
+		axis:  axis,
+	})
+	n := len(m.nodes) - 1
+
+	left := m.build(indices[:mid], points, depth+1)
+	right := m.build(indices[mid+1:], points, depth+1)
+	m.nodes[n].left = left
+	m.nodes[n].right = right
+
+	return n
+}
+
+type kdCandidate struct {
+	index    int
+	distance float64
+}
+
+// nearest collects the k palette entries closest to p by Euclidean
+// distance into best, sorted nearest first. Ties go to the lower index.
+func (m *KDTreeMatcher) nearest(n int, p [3]float64, k int, best []kdCandidate) []kdCandidate {
+	if n < 0 {
+		return best
+	}
+	node := &m.nodes[n]
+
+	d := euclidean(p, node.point)
+	if len(best) < k || d < best[len(best)-1].distance ||
+		(d == best[len(best)-1].distance && node.index < best[len(best)-1].index) {
+		i := sort.Search(len(best), func(i int) bool {
+			return best[i].distance > d || (best[i].distance == d && best[i].index > node.index)
+		})
+		if len(best) < k {
+			best = append(best, kdCandidate{})
+		}
+		copy(best[i+1:], best[i:len(best)-1])
+		best[i] = kdCandidate{index: node.index, distance: d}
+	}
+
+	diff := p[node.axis] - node.point[node.axis]
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	best = m.nearest(near, p, k, best)
+	if len(best) < k || math.Abs(diff) <= best[len(best)-1].distance {
+		best = m.nearest(far, p, k, best)
+	}
+	return best
+}
+
+func euclidean(x, y [3]float64) float64 {
+	d0, d1, d2 := x[0]-y[0], x[1]-y[1], x[2]-y[2]
+	return math.Sqrt(d0*d0 + d1*d1 + d2*d2)
+}
+
+func (m *KDTreeMatcher) Nearest(c color.Color) common.ThreadColor {
+	i := m.NearestIndex(c)
+	if i < 0 {
+		return common.ThreadColor{}
+	}
+	return m.palette[i]
+}
+
+// NearestIndex returns the palette index of the closest thread, or -1 for
+// an empty palette. It is safe for concurrent use.
+func (m *KDTreeMatcher) NearestIndex(c color.Color) int {
+	if m.root < 0 {
+		return -1
+	}
+	p := point(toRGBA(c), m.metric)
+
+	if m.metric == MetricRGB || m.metric == MetricCIE76 {
+		return m.nearest(m.root, p, 1, make([]kdCandidate, 0, 1))[0].index
+	}
+
+	candidates := m.nearest(m.root, p, rerankCandidates, make([]kdCandidate, 0, rerankCandidates))
+	best, bestDistance := -1, math.MaxFloat64
+	for _, cand := range candidates {
+		distance := m.metric.distance(p, m.points[cand.index])
+		if distance < bestDistance || (distance == bestDistance && cand.index < best) {
+			best, bestDistance = cand.index, distance
+		}
+	}
+
+	return best
+}
+
+// CachedMatcher memoizes another matcher's answers by 8-bit RGB value. It is
+// safe for concurrent use.
+type CachedMatcher struct {
+	matcher ColorMatcher
+	mu      sync.RWMutex
+	cache   map[uint32]common.ThreadColor
+}
+
+// NewCachedMatcher wraps matcher with an RGB-keyed cache.
+func NewCachedMatcher(matcher ColorMatcher) *CachedMatcher {
+	return &CachedMatcher{matcher: matcher, cache: make(map[uint32]common.ThreadColor)}
+}
+
+func (m *CachedMatcher) Nearest(c color.Color) common.ThreadColor {
+	rgba := toRGBA(c)
+	key := uint32(rgba.R)<<16 | uint32(rgba.G)<<8 | uint32(rgba.B)
+
+	m.mu.RLock()
+	tc, ok := m.cache[key]
+	m.mu.RUnlock()
+	if ok {
+		return tc
+	}
+
+	tc = m.matcher.Nearest(rgba)
+	m.mu.Lock()
+	m.cache[key] = tc
+	m.mu.Unlock()
+	return tc
+}
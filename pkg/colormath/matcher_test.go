@@ -0,0 +1,93 @@
+package colormath_test
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/threads"
+)
+
+// cubeColors returns a grid through the RGB cube, step apart on each axis.
+func cubeColors(step int) []color.RGBA {
+	var colors []color.RGBA
+	for r := 0; r < 256; r += step {
+		for g := 0; g < 256; g += step {
+			for b := 0; b < 256; b += step {
+				colors = append(colors, color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255})
+			}
+		}
+	}
+	return colors
+}
+
+// linearScan returns the palette index closest to c under metric by
+// working out the full metric for every entry. labs holds the palette in
+// Lab.
+func linearScan(palette []common.ThreadColor, labs []colormath.Lab, metric colormath.Metric, c color.RGBA) int {
+	lab := colormath.RGBToLab(c.R, c.G, c.B)
+	nearest, minDistance := -1, math.MaxFloat64
+	for i, tc := range palette {
+		var distance float64
+		if metric.UsesLab() {
+			distance = metric.LabDistance(lab, labs[i])
+		} else {
+			dr, dg, db := float64(c.R)-float64(tc.Color.R), float64(c.G)-float64(tc.Color.G), float64(c.B)-float64(tc.Color.B)
+			distance = math.Sqrt(dr*dr + dg*dg + db*db)
+		}
+		if distance < minDistance {
+			nearest, minDistance = i, distance
+		}
+	}
+	return nearest
+}
+
+func TestIndexMatcherAgreesWithLinear(t *testing.T) {
+	palette, err := threads.LoadPalette("../../assets/thread_colors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := cubeColors(15)
+	labs := make([]colormath.Lab, len(palette))
+	for i, tc := range palette {
+		labs[i] = colormath.RGBToLab(tc.Color.R, tc.Color.G, tc.Color.B)
+	}
+
+	for _, metric := range colormath.Metrics {
+		want := make([]int, len(colors))
+		for i, c := range colors {
+			want[i] = linearScan(palette, labs, metric, c)
+		}
+		matchers := map[string]colormath.IndexMatcher{
+			"NewIndexMatcher": colormath.NewIndexMatcher(palette, metric),
+		}
+		// The k-d tree is exact only where the metric is Euclidean in its
+		// space.
+		if metric == colormath.MetricRGB || metric == colormath.MetricCIE76 {
+			matchers["KDTreeMatcher"] = colormath.NewKDTreeMatcher(palette, metric)
+		}
+		matchers["LinearMatcher"] = colormath.NewLinearMatcher(palette, metric)
+
+		for name, matcher := range matchers {
+			misses := 0
+			for i, c := range colors {
+				if matcher.NearestIndex(c) != want[i] {
+					misses++
+				}
+			}
+			if misses != 0 {
+				t.Errorf("%s with %s: %d of %d colors differ from a linear scan, want none", name, metric, misses, len(colors))
+			}
+		}
+	}
+}
+
+func TestMatcherEmptyPalette(t *testing.T) {
+	for _, metric := range colormath.Metrics {
+		if i := colormath.NewIndexMatcher(nil, metric).NearestIndex(color.White); i != -1 {
+			t.Errorf("%s: NearestIndex on an empty palette = %d, want -1", metric, i)
+		}
+	}
+}
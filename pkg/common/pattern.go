@@ -0,0 +1,349 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+)
+
+// PatternVersion is the version written by Pattern.Save. LoadPattern reads
+// any version up to and including it.
+const PatternVersion = 2
+
+// EmptyCell marks a pattern cell that is left unstitched.
+const EmptyCell = -1
+
+// PaletteEntry is a thread used by a pattern and how many stitches use it.
+type PaletteEntry struct {
+	Thread ThreadColor
+	Count  int
+}
+
+// PatternMetadata describes a pattern and where it came from.
+type PatternMetadata struct {
+	Title       string `json:"title,omitempty"`
+	Author      string `json:"author,omitempty"`
+	FabricCount int    `json:"fabricCount,omitempty"`
+	// SourceImageHash is the hex SHA-256 of the image the pattern was
+	// generated from.
+	SourceImageHash string `json:"sourceImageHash,omitempty"`
+}
+
+// Backstitch is a straight line stitched between two points of the grid.
+// Coordinates count cell edges from the top left corner, so (0, 0) to (1, 1)
+// runs diagonally across the first cell; halves reach cell centres.
+type Backstitch struct {
+	X1, Y1, X2, Y2 float64
+	PaletteIndex   int
+}
+
+// Pattern is a cross stitch chart: a grid of cells, each holding an index
+// into the palette or EmptyCell, plus any backstitches drawn over it.
+type Pattern struct {
+	Width, Height int
+	Palette       []PaletteEntry
+	// Cells holds palette indices row by row.
+	Cells        []int
+	Backstitches []Backstitch
+	Metadata     PatternMetadata
+}
+
+// NewPattern builds a pattern from a grid of thread colors. Threads are added
+// to the palette in the order they first appear; empty threads become empty
+// cells.
+func NewPattern(grid [][]ThreadColor) *Pattern {
+	p := &Pattern{Height: len(grid)}
+	if p.Height > 0 {
+		p.Width = len(grid[0])
+	}
+	p.Cells = make([]int, p.Width*p.Height)
+
+	indices := make(map[ThreadColor]int)
+	for y, row := range grid {
+		for x, tc := range row {
+			if tc.IsEmpty() {
+				p.Cells[y*p.Width+x] = EmptyCell
+				continue
+			}
+			i, ok := indices[tc]
+			if !ok {
+				i = len(p.Palette)
+				indices[tc] = i
+				p.Palette = append(p.Palette, PaletteEntry{Thread: tc})
+			}
+			p.Cells[y*p.Width+x] = i
+			p.Palette[i].Count++
+		}
+	}
+
+	return p
+}
+
+// InBounds reports whether (x, y) is a cell of the pattern.
+func (p *Pattern) InBounds(x, y int) bool {
+	return x >= 0 && x < p.Width && y >= 0 && y < p.Height
+}
+
+// At returns the thread at (x, y) and false if the cell is empty or out of
+// bounds.
+func (p *Pattern) At(x, y int) (ThreadColor, bool) {
+	if !p.InBounds(x, y) {
+		return ThreadColor{}, false
+	}
+	i := p.Cells[y*p.Width+x]
+	if i == EmptyCell {
+		return ThreadColor{}, false
+	}
+	return p.Palette[i].Thread, true
+}
+
+// Set changes the cell at (x, y) to a palette index or EmptyCell, keeping
+// stitch counts up to date.
+func (p *Pattern) Set(x, y, paletteIndex int) error {
+	if !p.InBounds(x, y) {
+		return fmt.Errorf("cell (%d, %d) is outside the %dx%d pattern", x, y, p.Width, p.Height)
+	}
+	if paletteIndex != EmptyCell && (paletteIndex < 0 || paletteIndex >= len(p.Palette)) {
+		return fmt.Errorf("palette index %d out of range", paletteIndex)
+	}
+
+	cell := &p.Cells[y*p.Width+x]
+	if *cell != EmptyCell {
+		p.Palette[*cell].Count--
+	}
+	*cell = paletteIndex
+	if paletteIndex != EmptyCell {
+		p.Palette[paletteIndex].Count++
+	}
+	return nil
+}
+
+// AddThread returns the palette index of tc, appending it if it is not in
+// the palette yet.
+func (p *Pattern) AddThread(tc ThreadColor) int {
+	for i, entry := range p.Palette {
+		if entry.Thread == tc {
+			return i
+		}
+	}
+	p.Palette = append(p.Palette, PaletteEntry{Thread: tc})
+	return len(p.Palette) - 1
+}
+
+// Threads returns the palette's thread colors in palette order.
+func (p *Pattern) Threads() []ThreadColor {
+	threads := make([]ThreadColor, len(p.Palette))
+	for i, entry := range p.Palette {
+		threads[i] = entry.Thread
+	}
+	return threads
+}
+
+// Grid expands the pattern back into rows of thread colors. Empty cells are
+// the zero ThreadColor.
+func (p *Pattern) Grid() [][]ThreadColor {
+	grid := make([][]ThreadColor, p.Height)
+	for y := range grid {
+		row := make([]ThreadColor, p.Width)
+		for x := range row {
+			row[x], _ = p.At(x, y)
+		}
+		grid[y] = row
+	}
+	return grid
+}
+
+// Recount recomputes every palette entry's stitch count from the cells.
+func (p *Pattern) Recount() {
+	for i := range p.Palette {
+		p.Palette[i].Count = 0
+	}
+	for _, i := range p.Cells {
+		if i != EmptyCell {
+			p.Palette[i].Count++
+		}
+	}
+}
+
+type patternThreadJSON struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Color  string `json:"color"`
+	Symbol string `json:"symbol,omitempty"`
+	Brand  string `json:"brand,omitempty"`
+	Count  int    `json:"count"`
+	// Blend lists the two strands of a blended thread.
+	Blend []patternStrandJSON `json:"blend,omitempty"`
+}
+
+type patternStrandJSON struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Brand string `json:"brand,omitempty"`
+	Color string `json:"color"`
+}
+
+type backstitchJSON struct {
+	X1      float64 `json:"x1"`
+	Y1      float64 `json:"y1"`
+	X2      float64 `json:"x2"`
+	Y2      float64 `json:"y2"`
+	Palette int     `json:"palette"`
+}
+
+type patternJSON struct {
+	Version      int                 `json:"version"`
+	Width        int                 `json:"width"`
+	Height       int                 `json:"height"`
+	Metadata     PatternMetadata     `json:"metadata"`
+	Palette      []patternThreadJSON `json:"palette"`
+	Cells        []int               `json:"cells"`
+	Backstitches []backstitchJSON    `json:"backstitches,omitempty"`
+}
+
+// Save writes the pattern as versioned JSON.
+func (p *Pattern) Save(w io.Writer) error {
+	out := patternJSON{
+		Version:  PatternVersion,
+		Width:    p.Width,
+		Height:   p.Height,
+		Metadata: p.Metadata,
+		Palette:  make([]patternThreadJSON, len(p.Palette)),
+		Cells:    p.Cells,
+	}
+	for _, b := range p.Backstitches {
+		out.Backstitches = append(out.Backstitches, backstitchJSON{X1: b.X1, Y1: b.Y1, X2: b.X2, Y2: b.Y2, Palette: b.PaletteIndex})
+	}
+	for i, entry := range p.Palette {
+		out.Palette[i] = patternThreadJSON{
+			ID:     entry.Thread.ID,
+			Name:   entry.Thread.Name,
+			Color:  hexColor(entry.Thread.Color),
+			Symbol: entry.Thread.Symbol,
+			Brand:  entry.Thread.Brand,
+			Count:  entry.Count,
+		}
+		if entry.Thread.IsBlend() {
+			for _, strand := range entry.Thread.Blend {
+				out.Palette[i].Blend = append(out.Palette[i].Blend, patternStrandJSON{
+					ID:    strand.ID,
+					Name:  strand.Name,
+					Brand: strand.Brand,
+					Color: hexColor(strand.Color),
+				})
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// LoadPattern reads a pattern written by Save. Stitch counts are recomputed
+// from the cells rather than trusted.
+func LoadPattern(r io.Reader) (*Pattern, error) {
+	var in patternJSON
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, fmt.Errorf("failed to decode pattern: %w", err)
+	}
+	if in.Version < 1 || in.Version > PatternVersion {
+		return nil, fmt.Errorf("unsupported pattern version %d", in.Version)
+	}
+	if in.Width < 0 || in.Height < 0 || len(in.Cells) != in.Width*in.Height {
+		return nil, fmt.Errorf("pattern has %d cells, want %dx%d", len(in.Cells), in.Width, in.Height)
+	}
+
+	p := &Pattern{
+		Width:    in.Width,
+		Height:   in.Height,
+		Palette:  make([]PaletteEntry, len(in.Palette)),
+		Cells:    in.Cells,
+		Metadata: in.Metadata,
+	}
+	for i, t := range in.Palette {
+		c, err := parseHexColor(t.Color)
+		if err != nil {
+			return nil, fmt.Errorf("palette entry %d: %w", i, err)
+		}
+		tc := ThreadColor{ID: t.ID, Name: t.Name, Color: c, Symbol: t.Symbol, Brand: t.Brand}
+		if len(t.Blend) > 0 {
+			if len(t.Blend) != 2 {
+				return nil, fmt.Errorf("palette entry %d: a blend needs 2 strands, got %d", i, len(t.Blend))
+			}
+			for j, strand := range t.Blend {
+				sc, err := parseHexColor(strand.Color)
+				if err != nil {
+					return nil, fmt.Errorf("palette entry %d strand %d: %w", i, j, err)
+				}
+				tc.Blend[j] = Strand{ID: strand.ID, Name: strand.Name, Brand: strand.Brand, Color: sc}
+			}
+		}
+		p.Palette[i].Thread = tc
+	}
+	for i, cell := range p.Cells {
+		if cell != EmptyCell && (cell < 0 || cell >= len(p.Palette)) {
+			return nil, fmt.Errorf("cell %d refers to palette index %d of %d", i, cell, len(p.Palette))
+		}
+	}
+	for i, b := range in.Backstitches {
+		if b.Palette < 0 || b.Palette >= len(p.Palette) {
+			return nil, fmt.Errorf("backstitch %d refers to palette index %d of %d", i, b.Palette, len(p.Palette))
+		}
+		p.Backstitches = append(p.Backstitches, Backstitch{X1: b.X1, Y1: b.Y1, X2: b.X2, Y2: b.Y2, PaletteIndex: b.Palette})
+	}
+	p.Recount()
+
+	return p, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	var c color.RGBA
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid color %q", s)
+	}
+	return c, nil
+}
+
+// SaveFile writes the pattern to a JSON file.
+func (p *Pattern) SaveFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := p.Save(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// LoadPatternFile reads a pattern from a JSON file.
+func LoadPatternFile(path string) (*Pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadPattern(file)
+}
+
+// HashSource returns the hex SHA-256 of r's contents, for
+// PatternMetadata.SourceImageHash.
+func HashSource(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
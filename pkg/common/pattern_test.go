@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPatternSaveLoadRoundTrip(t *testing.T) {
+	black := ThreadColor{ID: 310, Name: "Black", Color: color.RGBA{R: 0, G: 0, B: 0}, Symbol: "●", Brand: "DMC"}
+	white := ThreadColor{ID: 3865, Name: "Winter White", Color: color.RGBA{R: 249, G: 247, B: 241}, Symbol: "○", Brand: "DMC"}
+	tweed := ThreadColor{
+		Name:   "Black + Winter White",
+		Color:  color.RGBA{R: 125, G: 124, B: 121},
+		Symbol: "▲",
+		Blend: [2]Strand{
+			{ID: 310, Name: "Black", Brand: "DMC"},
+			{ID: 3865, Name: "Winter White", Brand: "DMC", Color: color.RGBA{R: 249, G: 247, B: 241}},
+		},
+	}
+	var empty ThreadColor
+
+	tests := []struct {
+		name    string
+		pattern func() *Pattern
+	}{
+		{"single threads", func() *Pattern {
+			return NewPattern([][]ThreadColor{{black, white}, {white, black}})
+		}},
+		{"blend", func() *Pattern {
+			return NewPattern([][]ThreadColor{{black, tweed, white}})
+		}},
+		{"empty cells", func() *Pattern {
+			return NewPattern([][]ThreadColor{{empty, black}, {white, empty}, {empty, empty}})
+		}},
+		{"backstitches", func() *Pattern {
+			p := NewPattern([][]ThreadColor{{black, white}, {white, empty}})
+			p.Backstitches = []Backstitch{
+				{X1: 0, Y1: 0, X2: 1, Y2: 1, PaletteIndex: 0},
+				{X1: 0.5, Y1: 2, X2: 2, Y2: 0.5, PaletteIndex: 1},
+			}
+			return p
+		}},
+		{"symbols and metadata", func() *Pattern {
+			p := NewPattern([][]ThreadColor{{black, tweed}})
+			p.Palette[0].Thread.Symbol = "★"
+			p.Metadata = PatternMetadata{Title: "Lavender", Author: "Kytlin", FabricCount: 14, SourceImageHash: "00ff"}
+			return p
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.pattern()
+			var buf bytes.Buffer
+			if err := want.Save(&buf); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadPattern(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadPatternRejectsVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+	}{
+		{"missing", ""},
+		{"zero", `"version": 0,`},
+		{"negative", `"version": -1,`},
+		{"newer", fmt.Sprintf(`"version": %d,`, PatternVersion+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{` + tt.version + `"width": 1, "height": 1, "palette": [{"id": 310, "name": "Black", "color": "#000000"}], "cells": [0]}`
+			_, err := LoadPattern(strings.NewReader(input))
+			if err == nil || !strings.Contains(err.Error(), "unsupported pattern version") {
+				t.Errorf("got error %v, want an unsupported version", err)
+			}
+		})
+	}
+}
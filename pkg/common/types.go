@@ -2,11 +2,76 @@ package common
 
 import (
 	"image/color"
+	"strconv"
 )
 
+// DefaultBrand is the brand of threads that do not name one.
+const DefaultBrand = "DMC"
+
 type ThreadColor struct {
 	ID     int
 	Name   string
 	Color  color.RGBA
 	Symbol string
+	// Brand is the thread manufacturer, such as "DMC" or "Anchor". Empty
+	// means DefaultBrand.
+	Brand string
+	// Blend holds the two real threads of a blended (tweed) thread, worked
+	// with one strand of each. It is zero for a single thread. A blend's
+	// Color is the mix of its strands and its ID is 0.
+	Blend [2]Strand
+}
+
+// Strand is one of the two real threads of a blended ThreadColor.
+type Strand struct {
+	ID    int
+	Name  string
+	Brand string
+	Color color.RGBA
+}
+
+// IsEmpty reports whether the thread is the zero ThreadColor, which grids
+// use for unstitched cells.
+func (t ThreadColor) IsEmpty() bool {
+	return t == ThreadColor{}
+}
+
+// IsBlend reports whether the thread is a blend of two real threads.
+func (t ThreadColor) IsBlend() bool {
+	return t.Blend != [2]Strand{}
+}
+
+// Parts returns the real threads the thread is stitched with: the thread
+// itself, or the two strands of a blend.
+func (t ThreadColor) Parts() []ThreadColor {
+	if !t.IsBlend() {
+		return []ThreadColor{t}
+	}
+	parts := make([]ThreadColor, 2)
+	for i, s := range t.Blend {
+		parts[i] = ThreadColor{ID: s.ID, Name: s.Name, Color: s.Color, Brand: s.Brand}
+	}
+	return parts
+}
+
+// BrandName returns the thread's brand, or DefaultBrand if it has none. A
+// blend takes the brand of its first strand.
+func (t ThreadColor) BrandName() string {
+	if t.IsBlend() {
+		return t.Parts()[0].BrandName()
+	}
+	if t.Brand == "" {
+		return DefaultBrand
+	}
+	return t.Brand
+}
+
+// Code is the brand and number a thread is bought by, such as "DMC 310",
+// or both codes joined by " + " for a blend.
+func (t ThreadColor) Code() string {
+	if t.IsBlend() {
+		parts := t.Parts()
+		return parts[0].Code() + " + " + parts[1].Code()
+	}
+	return t.BrandName() + " " + strconv.Itoa(t.ID)
 }
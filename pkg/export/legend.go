@@ -0,0 +1,64 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/materials"
+)
+
+// SaveLegendCSV writes the pattern's legend as a CSV file.
+func SaveLegendCSV(path string, p *common.Pattern, opts materials.Options) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := WriteLegendCSV(file, p, opts); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteLegendCSV writes one row per used thread with its symbol, color,
+// stitch count and estimated length, skeins and cost, followed by a total
+// row. The cost columns are empty when opts has no price table.
+func WriteLegendCSV(w io.Writer, p *common.Pattern, opts materials.Options) error {
+	estimate := materials.EstimatePattern(p, opts)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"symbol", "thread", "name", "color", "stitches", "length_m", "skeins", "skeins_to_buy", "cost"})
+	for _, u := range estimate.Used() {
+		tc := u.Thread
+		cost := ""
+		if u.Priced {
+			cost = fmt.Sprintf("%.2f", u.Cost)
+		}
+		cw.Write([]string{
+			tc.Symbol,
+			tc.Code(),
+			tc.Name,
+			hexColor(tc.Color),
+			strconv.Itoa(u.Stitches),
+			fmt.Sprintf("%.2f", u.Length),
+			fmt.Sprintf("%.2f", u.Skeins),
+			strconv.Itoa(u.SkeinsToBuy),
+			cost,
+		})
+	}
+
+	totalCost := ""
+	if estimate.Priced {
+		totalCost = fmt.Sprintf("%.2f", estimate.Cost)
+	}
+	cw.Write([]string{"", "", "Total", "", strconv.Itoa(estimate.Stitches),
+		fmt.Sprintf("%.2f", estimate.Length), "", strconv.Itoa(estimate.SkeinsToBuy), totalCost})
+
+	cw.Flush()
+	return cw.Error()
+}
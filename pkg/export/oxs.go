@@ -0,0 +1,255 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// oxsSoftware is written into the software attribute of exported charts.
+const oxsSoftware = "Cross-stitch-image-generator"
+
+// OXS files describe a chart with a palette whose first item is the fabric
+// ("cloth"), full stitches that refer to palette items by index, and
+// backstitches between grid points.
+type oxsChart struct {
+	XMLName      xml.Name         `xml:"chart"`
+	Format       oxsFormat        `xml:"format"`
+	Properties   oxsProperties    `xml:"properties"`
+	Palette      []oxsPaletteItem `xml:"palette>palette_item"`
+	FullStitches []oxsStitch      `xml:"fullstitches>stitch"`
+	Backstitches []oxsBackstitch  `xml:"backstitches>backstitch"`
+}
+
+type oxsFormat struct {
+	Comments string `xml:"comments01,attr,omitempty"`
+}
+
+type oxsProperties struct {
+	Version         string `xml:"oxsversion,attr"`
+	Software        string `xml:"software,attr"`
+	ChartHeight     int    `xml:"chartheight,attr"`
+	ChartWidth      int    `xml:"chartwidth,attr"`
+	ChartTitle      string `xml:"charttitle,attr"`
+	Author          string `xml:"author,attr"`
+	StitchesPerInch string `xml:"stitchesperinch,attr,omitempty"`
+	PaletteCount    int    `xml:"palettecount,attr"`
+}
+
+type oxsPaletteItem struct {
+	Index   int    `xml:"index,attr"`
+	Number  string `xml:"number,attr"`
+	Name    string `xml:"name,attr"`
+	Color   string `xml:"color,attr"`
+	Symbol  string `xml:"symbol,attr,omitempty"`
+	Strands string `xml:"strands,attr,omitempty"`
+}
+
+type oxsStitch struct {
+	X        int `xml:"x,attr"`
+	Y        int `xml:"y,attr"`
+	PalIndex int `xml:"palindex,attr"`
+}
+
+type oxsBackstitch struct {
+	X1         float64 `xml:"x1,attr"`
+	Y1         float64 `xml:"y1,attr"`
+	X2         float64 `xml:"x2,attr"`
+	Y2         float64 `xml:"y2,attr"`
+	PalIndex   int     `xml:"palindex,attr"`
+	ObjectType string  `xml:"objecttype,attr,omitempty"`
+}
+
+// SaveOXS writes the pattern as an OXS file.
+func SaveOXS(path string, p *common.Pattern) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := WriteOXS(file, p); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteOXS writes the pattern in the Open Cross Stitch XML format used to
+// exchange charts with other stitching software. Threads are numbered by
+// brand and ID, such as "DMC 310", and the fabric is written as palette
+// item 0.
+func WriteOXS(w io.Writer, p *common.Pattern) error {
+	chart := oxsChart{
+		Format: oxsFormat{Comments: "Open Cross Stitch chart"},
+		Properties: oxsProperties{
+			Version:      "1.0",
+			Software:     oxsSoftware,
+			ChartHeight:  p.Height,
+			ChartWidth:   p.Width,
+			ChartTitle:   p.Metadata.Title,
+			Author:       p.Metadata.Author,
+			PaletteCount: len(p.Palette),
+		},
+		Palette: []oxsPaletteItem{{Index: 0, Number: "cloth", Name: "cloth", Color: "FFFFFF"}},
+	}
+	if p.Metadata.FabricCount > 0 {
+		chart.Properties.StitchesPerInch = strconv.Itoa(p.Metadata.FabricCount)
+	}
+
+	for i, entry := range p.Palette {
+		tc := entry.Thread
+		chart.Palette = append(chart.Palette, oxsPaletteItem{
+			Index:   i + 1,
+			Number:  tc.Code(),
+			Name:    tc.Name,
+			Color:   fmt.Sprintf("%02X%02X%02X", tc.Color.R, tc.Color.G, tc.Color.B),
+			Symbol:  tc.Symbol,
+			Strands: "2",
+		})
+	}
+	for i, cell := range p.Cells {
+		if cell == common.EmptyCell {
+			continue
+		}
+		chart.FullStitches = append(chart.FullStitches, oxsStitch{X: i % p.Width, Y: i / p.Width, PalIndex: cell + 1})
+	}
+	for _, b := range p.Backstitches {
+		chart.Backstitches = append(chart.Backstitches, oxsBackstitch{
+			X1: b.X1, Y1: b.Y1, X2: b.X2, Y2: b.Y2,
+			PalIndex:   b.PaletteIndex + 1,
+			ObjectType: "backstitch",
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(chart); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// LoadOXS reads a pattern from an OXS file.
+func LoadOXS(path string) (*common.Pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadOXS(file)
+}
+
+// ReadOXS reads an Open Cross Stitch XML chart. Full stitches and
+// backstitches are kept; part stitches, knots and beads are ignored. Cells
+// without a full stitch are left empty. Blends come back as single threads
+// with ID 0, since OXS does not record their strands.
+func ReadOXS(r io.Reader) (*common.Pattern, error) {
+	var chart oxsChart
+	if err := xml.NewDecoder(r).Decode(&chart); err != nil {
+		return nil, fmt.Errorf("failed to decode oxs: %w", err)
+	}
+
+	width, height := chart.Properties.ChartWidth, chart.Properties.ChartHeight
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("oxs chart has invalid size %dx%d", width, height)
+	}
+
+	p := &common.Pattern{
+		Width:  width,
+		Height: height,
+		Cells:  make([]int, width*height),
+		Metadata: common.PatternMetadata{
+			Title:  chart.Properties.ChartTitle,
+			Author: chart.Properties.Author,
+		},
+	}
+	if spi, err := strconv.ParseFloat(strings.TrimSpace(chart.Properties.StitchesPerInch), 64); err == nil && spi > 0 {
+		p.Metadata.FabricCount = int(spi + 0.5)
+	}
+	for i := range p.Cells {
+		p.Cells[i] = common.EmptyCell
+	}
+
+	// OXS palette indices are not guaranteed to be contiguous, so map them
+	// onto the pattern's palette as they are read. Item 0 is the fabric.
+	palette := make(map[int]int)
+	for _, item := range chart.Palette {
+		if item.Index == 0 {
+			continue
+		}
+		if _, ok := palette[item.Index]; ok {
+			return nil, fmt.Errorf("oxs palette index %d is repeated", item.Index)
+		}
+		tc, err := oxsThread(item)
+		if err != nil {
+			return nil, err
+		}
+		palette[item.Index] = len(p.Palette)
+		p.Palette = append(p.Palette, common.PaletteEntry{Thread: tc})
+	}
+
+	for _, s := range chart.FullStitches {
+		if !p.InBounds(s.X, s.Y) {
+			return nil, fmt.Errorf("oxs stitch at (%d, %d) is outside the %dx%d chart", s.X, s.Y, width, height)
+		}
+		i, ok := palette[s.PalIndex]
+		if !ok {
+			return nil, fmt.Errorf("oxs stitch at (%d, %d) refers to unknown palette index %d", s.X, s.Y, s.PalIndex)
+		}
+		p.Cells[s.Y*width+s.X] = i
+	}
+	for _, b := range chart.Backstitches {
+		i, ok := palette[b.PalIndex]
+		if !ok {
+			return nil, fmt.Errorf("oxs backstitch refers to unknown palette index %d", b.PalIndex)
+		}
+		p.Backstitches = append(p.Backstitches, common.Backstitch{X1: b.X1, Y1: b.Y1, X2: b.X2, Y2: b.Y2, PaletteIndex: i})
+	}
+	p.Recount()
+
+	return p, nil
+}
+
+// oxsThread converts a palette item into a thread. The number is usually
+// "DMC 310"; its last field is taken as the thread ID when it is numeric,
+// and the fields before it as the brand.
+func oxsThread(item oxsPaletteItem) (common.ThreadColor, error) {
+	tc := common.ThreadColor{Name: item.Name, Symbol: item.Symbol}
+
+	hex := strings.TrimPrefix(strings.TrimSpace(item.Color), "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return tc, fmt.Errorf("oxs palette index %d: invalid color %q", item.Index, item.Color)
+	}
+	tc.Color = color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}
+
+	// Blends are numbered "DMC 310 + DMC 939"; OXS has no place for the
+	// strands' own colors, so they are read back as a single thread.
+	if strings.Contains(item.Number, "+") {
+		if tc.Name == "" {
+			tc.Name = item.Number
+		}
+		return tc, nil
+	}
+	if fields := strings.Fields(item.Number); len(fields) > 0 {
+		if id, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			tc.ID = id
+			tc.Brand = strings.Join(fields[:len(fields)-1], " ")
+		}
+	}
+	if tc.Name == "" {
+		tc.Name = item.Number
+	}
+	return tc, nil
+}
@@ -0,0 +1,85 @@
+package export
+
+import (
+	"bytes"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// roundTripOXS writes p as OXS and reads it back.
+func roundTripOXS(t *testing.T, p *common.Pattern) *common.Pattern {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteOXS(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadOXS(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestOXSRoundTrip(t *testing.T) {
+	black := common.ThreadColor{ID: 310, Name: "Black", Color: color.RGBA{R: 0, G: 0, B: 0}, Symbol: "⇐", Brand: "DMC"}
+	red := common.ThreadColor{ID: 321, Name: "Red", Color: color.RGBA{R: 199, G: 43, B: 59}, Symbol: "⇑", Brand: "DMC"}
+	var empty common.ThreadColor
+
+	p := common.NewPattern([][]common.ThreadColor{
+		{black, red, empty},
+		{empty, red, black},
+	})
+	p.Backstitches = []common.Backstitch{{X1: 0, Y1: 0, X2: 1.5, Y2: 2, PaletteIndex: 1}}
+	p.Metadata = common.PatternMetadata{Title: "Test", Author: "Someone", FabricCount: 14}
+
+	got := roundTripOXS(t, p)
+	if got.Width != p.Width || got.Height != p.Height {
+		t.Fatalf("size = %dx%d, want %dx%d", got.Width, got.Height, p.Width, p.Height)
+	}
+	if !reflect.DeepEqual(got.Palette, p.Palette) {
+		t.Errorf("palette = %+v, want %+v", got.Palette, p.Palette)
+	}
+	if !reflect.DeepEqual(got.Cells, p.Cells) {
+		t.Errorf("cells = %v, want %v", got.Cells, p.Cells)
+	}
+	if !reflect.DeepEqual(got.Backstitches, p.Backstitches) {
+		t.Errorf("backstitches = %+v, want %+v", got.Backstitches, p.Backstitches)
+	}
+	if got.Metadata.Title != p.Metadata.Title || got.Metadata.Author != p.Metadata.Author || got.Metadata.FabricCount != p.Metadata.FabricCount {
+		t.Errorf("metadata = %+v, want %+v", got.Metadata, p.Metadata)
+	}
+}
+
+// TestOXSBlendLoss documents that OXS has no place for a blend's strands:
+// a blend is read back as a single thread with the blend's mixed color and
+// code as its name, and ID 0.
+func TestOXSBlendLoss(t *testing.T) {
+	blend := common.ThreadColor{
+		Name:  "Black + Red",
+		Color: color.RGBA{R: 100, G: 22, B: 30},
+		Blend: [2]common.Strand{
+			{ID: 310, Name: "Black", Brand: "DMC", Color: color.RGBA{R: 0, G: 0, B: 0}},
+			{ID: 321, Name: "Red", Brand: "DMC", Color: color.RGBA{R: 199, G: 43, B: 59}},
+		},
+	}
+	p := common.NewPattern([][]common.ThreadColor{{blend, {}}})
+
+	got := roundTripOXS(t, p)
+	if len(got.Palette) != 1 {
+		t.Fatalf("palette has %d threads, want 1", len(got.Palette))
+	}
+	tc := got.Palette[0].Thread
+	want := common.ThreadColor{Name: "Black + Red", Color: blend.Color}
+	if tc != want {
+		t.Errorf("blend read back as %+v, want %+v", tc, want)
+	}
+	if tc.IsBlend() {
+		t.Errorf("blend kept its strands; update the OXS docs and this test")
+	}
+	if !reflect.DeepEqual(got.Cells, []int{0, common.EmptyCell}) {
+		t.Errorf("cells = %v, want [0 %d]", got.Cells, common.EmptyCell)
+	}
+}
@@ -0,0 +1,476 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/materials"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/render"
+)
+
+// PDFOptions controls the layout of a printable chart.
+type PDFOptions struct {
+	// Font is a TrueType font covering the chart's symbols, such as
+	// assets/DejaVuSans.ttf. It is required.
+	Font []byte
+	// PageSize is "A4" or "Letter". Defaults to A4.
+	PageSize string
+	// CellSize is the printed width of one stitch in millimetres.
+	// Defaults to 3.
+	CellSize float64
+	// Overlap is how many rows and columns are repeated on adjacent
+	// pages. Defaults to 2; a negative value disables overlap.
+	Overlap int
+	// FabricCount is the stitches per inch used for the finished size and
+	// skein estimates. Defaults to 14.
+	FabricCount int
+	// Materials controls the thread estimates in the legend. Its fabric
+	// count defaults to FabricCount.
+	Materials materials.Options
+}
+
+func (o PDFOptions) withDefaults() PDFOptions {
+	if o.PageSize == "" {
+		o.PageSize = "A4"
+	}
+	if o.CellSize <= 0 {
+		o.CellSize = 3
+	}
+	if o.Overlap < 0 {
+		o.Overlap = 0
+	} else if o.Overlap == 0 {
+		o.Overlap = 2
+	}
+	if o.FabricCount <= 0 {
+		o.FabricCount = 14
+	}
+	if o.Materials.FabricCount <= 0 {
+		o.Materials.FabricCount = o.FabricCount
+	}
+	return o
+}
+
+const (
+	pdfFont      = "DejaVu"
+	pdfMargin    = 10.0
+	pdfHeader    = 8.0
+	pdfGutter    = 7.0
+	pdfBoldEvery = 10
+)
+
+// chartTile is the part of a pattern printed on one chart page.
+type chartTile struct {
+	Col, Row   int
+	Cols, Rows int
+	Page       int
+}
+
+// SavePDF writes the pattern as a printable PDF file.
+func SavePDF(path string, p *common.Pattern, opts PDFOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := WritePDF(file, p, opts); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WritePDF writes the pattern as a printable chart: a cover page with a
+// preview, a page map, the chart split across pages with overlapping rows
+// and columns, and a legend.
+func WritePDF(w io.Writer, p *common.Pattern, opts PDFOptions) error {
+	opts = opts.withDefaults()
+	if len(opts.Font) == 0 {
+		return errors.New("pdf export needs a TrueType font for symbols")
+	}
+	if p.Width == 0 || p.Height == 0 {
+		return errors.New("pattern is empty")
+	}
+
+	pdf := fpdf.New("P", "mm", opts.PageSize, "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(false, pdfMargin)
+	pdf.AddUTF8FontFromBytes(pdfFont, "", opts.Font)
+	pdf.SetTitle(p.Metadata.Title, true)
+	pdf.SetAuthor(p.Metadata.Author, true)
+	pdf.SetCreator("Cross Stitch Image Generator", true)
+
+	tiles := layoutTiles(pdf, p, opts)
+
+	if err := writeCover(pdf, p, opts); err != nil {
+		return err
+	}
+	writePageMap(pdf, p, tiles)
+	for _, t := range tiles {
+		writeChartPage(pdf, p, t, len(tiles), opts)
+	}
+	writeLegend(pdf, p, opts)
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// chartArea returns the width and height available for cells on a chart
+// page.
+func chartArea(pdf *fpdf.Fpdf) (float64, float64) {
+	pageW, pageH := pdf.GetPageSize()
+	return pageW - 2*pdfMargin - pdfGutter, pageH - 2*pdfMargin - pdfHeader - pdfGutter
+}
+
+// layoutTiles splits the pattern into page-sized tiles. Neighbouring tiles
+// share opts.Overlap rows or columns. Chart pages follow the cover and the
+// page map, so the first is page 3.
+func layoutTiles(pdf *fpdf.Fpdf, p *common.Pattern, opts PDFOptions) []chartTile {
+	areaW, areaH := chartArea(pdf)
+	perRow := int(areaW / opts.CellSize)
+	perCol := int(areaH / opts.CellSize)
+
+	starts := func(total, per int) []int {
+		if total <= per {
+			return []int{0}
+		}
+		step := per - opts.Overlap
+		if step < 1 {
+			step = 1
+		}
+		var s []int
+		for start := 0; ; start += step {
+			s = append(s, start)
+			if start+per >= total {
+				break
+			}
+		}
+		return s
+	}
+
+	var tiles []chartTile
+	for _, row := range starts(p.Height, perCol) {
+		for _, col := range starts(p.Width, perRow) {
+			tiles = append(tiles, chartTile{
+				Col:  col,
+				Row:  row,
+				Cols: min(perRow, p.Width-col),
+				Rows: min(perCol, p.Height-row),
+				Page: len(tiles) + 3,
+			})
+		}
+	}
+	return tiles
+}
+
+func writeCover(pdf *fpdf.Fpdf, p *common.Pattern, opts PDFOptions) error {
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+
+	title := p.Metadata.Title
+	if title == "" {
+		title = "Cross Stitch Pattern"
+	}
+	pdf.SetFont(pdfFont, "", 24)
+	pdf.SetXY(pdfMargin, pdfMargin+5)
+	pdf.CellFormat(pageW-2*pdfMargin, 12, title, "", 1, "C", false, 0, "")
+	if p.Metadata.Author != "" {
+		pdf.SetFont(pdfFont, "", 14)
+		pdf.CellFormat(pageW-2*pdfMargin, 8, "by "+p.Metadata.Author, "", 1, "C", false, 0, "")
+	}
+
+	widthIn := float64(p.Width) / float64(opts.FabricCount)
+	heightIn := float64(p.Height) / float64(opts.FabricCount)
+	details := []string{
+		fmt.Sprintf("Design size: %d × %d stitches", p.Width, p.Height),
+		fmt.Sprintf("Colors: %d", countUsedThreads(p)),
+		fmt.Sprintf("Finished size on %d-count fabric: %.1f × %.1f in (%.1f × %.1f cm)",
+			opts.FabricCount, widthIn, heightIn, widthIn*2.54, heightIn*2.54),
+	}
+	estimate := materials.EstimatePattern(p, opts.Materials)
+	threads := fmt.Sprintf("Thread: %d skeins (%.1f m)", estimate.SkeinsToBuy, estimate.Length)
+	if estimate.Priced {
+		threads += fmt.Sprintf(", estimated cost %.2f", estimate.Cost)
+	}
+	details = append(details, threads)
+	pdf.SetFont(pdfFont, "", 11)
+	pdf.Ln(4)
+	for _, line := range details {
+		pdf.CellFormat(pageW-2*pdfMargin, 6, line, "", 1, "C", false, 0, "")
+	}
+
+	// Preview, scaled to fit the rest of the page.
+	previewCell := int(math.Max(1, math.Min(8, 600/float64(max(p.Width, p.Height)))))
+	renderer := render.NewRenderer(nil, render.StyleFilled)
+	renderer.CellSize = previewCell
+	renderer.BorderThickness = 0
+	var buf bytes.Buffer
+	if err := renderer.Encode(&buf, p.Grid(), render.FormatPNG); err != nil {
+		return err
+	}
+	pdf.RegisterImageOptionsReader("preview", fpdf.ImageOptions{ImageType: "PNG"}, &buf)
+
+	top := pdf.GetY() + 8
+	maxW := pageW - 2*pdfMargin
+	maxH := pageH - pdfMargin - top
+	scale := math.Min(maxW/float64(p.Width), maxH/float64(p.Height))
+	w, h := float64(p.Width)*scale, float64(p.Height)*scale
+	pdf.ImageOptions("preview", (pageW-w)/2, top, w, h, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	return nil
+}
+
+// writePageMap draws the chart outline with each chart page's area and page
+// number, so stitchers can find the page for any part of the design.
+func writePageMap(pdf *fpdf.Fpdf, p *common.Pattern, tiles []chartTile) {
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+
+	pdf.SetFont(pdfFont, "", 16)
+	pdf.SetXY(pdfMargin, pdfMargin)
+	pdf.CellFormat(pageW-2*pdfMargin, 10, "Page map", "", 1, "L", false, 0, "")
+
+	top := pdf.GetY() + 4
+	maxW := pageW - 2*pdfMargin
+	maxH := pageH - pdfMargin - top
+	scale := math.Min(maxW/float64(p.Width), maxH/float64(p.Height))
+	left := pdfMargin + (maxW-float64(p.Width)*scale)/2
+
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetLineWidth(0.5)
+	pdf.Rect(left, top, float64(p.Width)*scale, float64(p.Height)*scale, "D")
+
+	pdf.SetLineWidth(0.2)
+	pdf.SetDrawColor(80, 80, 80)
+	pdf.SetFont(pdfFont, "", 10)
+	for _, t := range tiles {
+		x := left + float64(t.Col)*scale
+		y := top + float64(t.Row)*scale
+		w := float64(t.Cols) * scale
+		h := float64(t.Rows) * scale
+		pdf.Rect(x, y, w, h, "D")
+		pdf.SetXY(x, y+h/2-3)
+		pdf.CellFormat(w, 6, "p. "+strconv.Itoa(t.Page), "", 0, "C", false, 0, "")
+	}
+}
+
+func writeChartPage(pdf *fpdf.Fpdf, p *common.Pattern, t chartTile, numTiles int, opts PDFOptions) {
+	pdf.AddPage()
+	pageW, _ := pdf.GetPageSize()
+	cell := opts.CellSize
+
+	pdf.SetFont(pdfFont, "", 9)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(pdfMargin, pdfMargin)
+	header := fmt.Sprintf("Chart %d of %d — columns %d–%d, rows %d–%d",
+		t.Page-2, numTiles, t.Col+1, t.Col+t.Cols, t.Row+1, t.Row+t.Rows)
+	if p.Metadata.Title != "" {
+		header = p.Metadata.Title + " — " + header
+	}
+	pdf.CellFormat(pageW-2*pdfMargin, pdfHeader-2, header, "", 0, "L", false, 0, "")
+	pdf.SetXY(pdfMargin, pdfMargin)
+	pdf.CellFormat(pageW-2*pdfMargin, pdfHeader-2, "p. "+strconv.Itoa(t.Page), "", 0, "R", false, 0, "")
+
+	left := pdfMargin + pdfGutter
+	top := pdfMargin + pdfHeader + pdfGutter
+
+	// Cells with their symbols.
+	pdf.SetFont(pdfFont, "", cell*2.2)
+	for row := 0; row < t.Rows; row++ {
+		for col := 0; col < t.Cols; col++ {
+			tc, ok := p.At(t.Col+col, t.Row+row)
+			if !ok {
+				continue
+			}
+			x := left + float64(col)*cell
+			y := top + float64(row)*cell
+			pdf.SetFillColor(int(tc.Color.R), int(tc.Color.G), int(tc.Color.B))
+			pdf.Rect(x, y, cell, cell, "F")
+
+			if tc.Symbol != "" {
+				if (float64(tc.Color.R)*0.299 + float64(tc.Color.G)*0.587 + float64(tc.Color.B)*0.114) > 186 {
+					pdf.SetTextColor(0, 0, 0)
+				} else {
+					pdf.SetTextColor(255, 255, 255)
+				}
+				pdf.SetXY(x, y)
+				pdf.CellFormat(cell, cell, tc.Symbol, "", 0, "C", false, 0, "")
+			}
+		}
+	}
+
+	// Grid lines, bold every ten stitches counted from the chart's edge.
+	for col := 0; col <= t.Cols; col++ {
+		setGridLine(pdf, t.Col+col)
+		x := left + float64(col)*cell
+		pdf.Line(x, top, x, top+float64(t.Rows)*cell)
+	}
+	for row := 0; row <= t.Rows; row++ {
+		setGridLine(pdf, t.Row+row)
+		y := top + float64(row)*cell
+		pdf.Line(left, y, left+float64(t.Cols)*cell, y)
+	}
+
+	// Shade the gutter next to rows and columns repeated from the
+	// previous page.
+	pdf.SetFillColor(220, 220, 220)
+	if t.Col > 0 && opts.Overlap > 0 {
+		pdf.Rect(left, top-2, float64(min(opts.Overlap, t.Cols))*cell, 1.5, "F")
+	}
+	if t.Row > 0 && opts.Overlap > 0 {
+		pdf.Rect(left-2, top, 1.5, float64(min(opts.Overlap, t.Rows))*cell, "F")
+	}
+
+	// Row and column numbers at every bold line.
+	pdf.SetFont(pdfFont, "", 6)
+	pdf.SetTextColor(0, 0, 0)
+	for col := 0; col <= t.Cols; col++ {
+		if n := t.Col + col; n%pdfBoldEvery == 0 && n > 0 {
+			x := left + float64(col)*cell
+			pdf.SetXY(x-5, top-pdfGutter+1)
+			pdf.CellFormat(10, 3, strconv.Itoa(n), "", 0, "C", false, 0, "")
+		}
+	}
+	for row := 0; row <= t.Rows; row++ {
+		if n := t.Row + row; n%pdfBoldEvery == 0 && n > 0 {
+			y := top + float64(row)*cell
+			pdf.SetXY(pdfMargin, y-1.5)
+			pdf.CellFormat(pdfGutter-2.5, 3, strconv.Itoa(n), "", 0, "R", false, 0, "")
+		}
+	}
+}
+
+func setGridLine(pdf *fpdf.Fpdf, n int) {
+	if n%pdfBoldEvery == 0 {
+		pdf.SetDrawColor(0, 0, 0)
+		pdf.SetLineWidth(0.4)
+	} else {
+		pdf.SetDrawColor(120, 120, 120)
+		pdf.SetLineWidth(0.1)
+	}
+}
+
+// writeLegend lists every used thread with its symbol, stitch count and
+// estimated length and skeins, and its cost when prices are known,
+// continuing onto further pages as needed. A final row gives the totals.
+func writeLegend(pdf *fpdf.Fpdf, p *common.Pattern, opts PDFOptions) {
+	_, pageH := pdf.GetPageSize()
+	estimate := materials.EstimatePattern(p, opts.Materials)
+	columns := []struct {
+		title string
+		width float64
+		align string
+	}{
+		{"Symbol", 15, "C"},
+		{"Thread", 22, "L"},
+		{"Name", 49, "L"},
+		{"Color", 14, "C"},
+		{"Stitches", 20, "R"},
+		{"Length (m)", 22, "R"},
+		{"Skeins", 22, "R"},
+	}
+	if estimate.Priced {
+		columns = append(columns, struct {
+			title string
+			width float64
+			align string
+		}{"Cost", 20, "R"})
+	}
+	// Rows are at least rowH high and grow by lineH for each extra line a
+	// long name or blend code wraps onto.
+	const rowH, lineH = 7.0, 4.5
+
+	header := func() {
+		pdf.AddPage()
+		pdf.SetFont(pdfFont, "", 16)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetXY(pdfMargin, pdfMargin)
+		pdf.CellFormat(0, 10, "Legend", "", 1, "L", false, 0, "")
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.SetDrawColor(120, 120, 120)
+		pdf.SetLineWidth(0.1)
+		for _, c := range columns {
+			pdf.CellFormat(c.width, rowH, c.title, "1", 0, c.align, true, 0, "")
+		}
+		pdf.Ln(rowH)
+	}
+	row := func(values []string, swatch *common.ThreadColor) {
+		lines := make([][]string, len(columns))
+		h := rowH
+		for i, c := range columns {
+			lines[i] = pdf.SplitText(values[i], c.width)
+			h = math.Max(h, float64(len(lines[i]))*lineH+rowH-lineH)
+		}
+		if pdf.GetY()+h > pageH-pdfMargin {
+			header()
+		}
+		y := pdf.GetY()
+		x := pdfMargin
+		for i, c := range columns {
+			pdf.Rect(x, y, c.width, h, "D")
+			top := y + (h-float64(len(lines[i]))*lineH)/2
+			for j, line := range lines[i] {
+				pdf.SetXY(x, top+float64(j)*lineH)
+				pdf.CellFormat(c.width, lineH, line, "", 0, c.align, false, 0, "")
+			}
+			if c.title == "Color" && swatch != nil {
+				pdf.SetFillColor(int(swatch.Color.R), int(swatch.Color.G), int(swatch.Color.B))
+				pdf.Rect(x+3, y+1.5, c.width-6, h-3, "FD")
+			}
+			x += c.width
+		}
+		pdf.SetXY(pdfMargin, y+h)
+	}
+
+	header()
+	for _, u := range estimate.Used() {
+		tc := u.Thread
+		values := []string{
+			tc.Symbol,
+			tc.Code(),
+			tc.Name,
+			"",
+			strconv.Itoa(u.Stitches),
+			fmt.Sprintf("%.1f", u.Length),
+			fmt.Sprintf("%d (%.2f)", u.SkeinsToBuy, u.Skeins),
+		}
+		if estimate.Priced {
+			values = append(values, formatCost(u))
+		}
+		row(values, &tc)
+	}
+
+	totals := []string{"", "", "Total", "", strconv.Itoa(estimate.Stitches),
+		fmt.Sprintf("%.1f", estimate.Length), strconv.Itoa(estimate.SkeinsToBuy)}
+	if estimate.Priced {
+		totals = append(totals, fmt.Sprintf("%.2f", estimate.Cost))
+	}
+	row(totals, nil)
+}
+
+// formatCost formats a thread's cost, or "?" when it has no price.
+func formatCost(u materials.ThreadUsage) string {
+	if !u.Priced {
+		return "?"
+	}
+	return fmt.Sprintf("%.2f", u.Cost)
+}
+
+func countUsedThreads(p *common.Pattern) int {
+	n := 0
+	for _, entry := range p.Palette {
+		if entry.Count > 0 {
+			n++
+		}
+	}
+	return n
+}
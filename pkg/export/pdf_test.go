@@ -0,0 +1,38 @@
+package export
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+func TestWritePDFWrapsLongLegendEntries(t *testing.T) {
+	font, err := os.ReadFile("../../assets/DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := common.ThreadColor{ID: 3865, Name: strings.Repeat("Very Light Antique Winter White ", 4), Color: color.RGBA{R: 249, G: 247, B: 241}, Symbol: "▲", Brand: "DMC"}
+	unbroken := common.ThreadColor{ID: 310, Name: strings.Repeat("Black", 20), Symbol: "●", Brand: "DMC"}
+	blend := common.ThreadColor{
+		Name:   long.Name + "+ " + unbroken.Name,
+		Color:  color.RGBA{R: 125, G: 124, B: 121},
+		Symbol: "★",
+		Blend: [2]common.Strand{
+			{ID: 3865, Name: long.Name, Brand: "Anchor Marlitt", Color: long.Color},
+			{ID: 310, Name: unbroken.Name, Brand: "Anchor Marlitt"},
+		},
+	}
+	p := common.NewPattern([][]common.ThreadColor{{long, unbroken, blend}})
+
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, p, PDFOptions{Font: font}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+		t.Error("output is not a PDF")
+	}
+}
@@ -0,0 +1,153 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/render"
+)
+
+// SVGOptions controls how a chart is drawn as SVG.
+type SVGOptions struct {
+	Style render.Style
+	// CellSize is the width of one stitch in SVG user units. Defaults
+	// to 20, matching the raster charts.
+	CellSize float64
+	// FontFamily names the font used for symbols. Defaults to
+	// "DejaVu Sans", the font the raster charts use.
+	FontFamily string
+}
+
+func (o SVGOptions) withDefaults() SVGOptions {
+	if o.CellSize <= 0 {
+		o.CellSize = 20
+	}
+	if o.FontFamily == "" {
+		o.FontFamily = "DejaVu Sans"
+	}
+	return o
+}
+
+// SaveSVG writes the pattern as an SVG file.
+func SaveSVG(path string, p *common.Pattern, opts SVGOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := WriteSVG(file, p, opts); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteSVG writes the pattern as a vector chart in the given style. Each
+// cell is a rectangle or a pair of crossed strokes, symbols are text, and
+// cells of one thread share a group so the chart is easy to edit in vector
+// tools.
+func WriteSVG(w io.Writer, p *common.Pattern, opts SVGOptions) error {
+	opts = opts.withDefaults()
+	cell := opts.CellSize
+	width := float64(p.Width) * cell
+	height := float64(p.Height) * cell
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%s\" height=\"%s\" viewBox=\"0 0 %s %s\">\n",
+		num(width), num(height), num(width), num(height))
+	if p.Metadata.Title != "" {
+		fmt.Fprintf(bw, "  <title>%s</title>\n", escapeXML(p.Metadata.Title))
+	}
+	if opts.Style == render.StyleXStitch {
+		fmt.Fprintf(bw, "  <rect width=\"%s\" height=\"%s\" fill=\"#ffffff\"/>\n", num(width), num(height))
+	}
+
+	for i, entry := range p.Palette {
+		if entry.Count == 0 {
+			continue
+		}
+		tc := entry.Thread
+		fill := hexColor(tc.Color)
+		fmt.Fprintf(bw, "  <g id=\"thread-%d\" data-thread=\"%d\" data-name=\"%s\">\n", i, tc.ID, escapeXML(tc.Name))
+
+		if opts.Style == render.StyleXStitch {
+			fmt.Fprintf(bw, "    <path fill=\"none\" stroke=\"%s\" stroke-width=\"%s\" stroke-linecap=\"round\" d=\"", fill, num(cell*3/20))
+			inset := cell * 0.1
+			forEachCell(p, i, func(x, y float64) {
+				x0, y0 := x*cell+inset, y*cell+inset
+				x1, y1 := (x+1)*cell-inset, (y+1)*cell-inset
+				fmt.Fprintf(bw, "M%s %sL%s %sM%s %sL%s %s",
+					num(x0), num(y0), num(x1), num(y1), num(x0), num(y1), num(x1), num(y0))
+			})
+			fmt.Fprintf(bw, "\"/>\n")
+		} else {
+			fmt.Fprintf(bw, "    <path fill=\"%s\" d=\"", fill)
+			forEachCell(p, i, func(x, y float64) {
+				fmt.Fprintf(bw, "M%s %sh%sv%sh-%sz", num(x*cell), num(y*cell), num(cell), num(cell), num(cell))
+			})
+			fmt.Fprintf(bw, "\"/>\n")
+		}
+
+		if opts.Style == render.StyleSymbol && tc.Symbol != "" {
+			textColor := "#ffffff"
+			if (float64(tc.Color.R)*0.299 + float64(tc.Color.G)*0.587 + float64(tc.Color.B)*0.114) > 186 {
+				textColor = "#000000"
+			}
+			fmt.Fprintf(bw, "    <g fill=\"%s\" font-family=\"%s\" font-size=\"%s\" text-anchor=\"middle\" dominant-baseline=\"central\">\n",
+				textColor, escapeXML(opts.FontFamily), num(cell*0.75))
+			symbol := escapeXML(tc.Symbol)
+			forEachCell(p, i, func(x, y float64) {
+				fmt.Fprintf(bw, "      <text x=\"%s\" y=\"%s\">%s</text>\n", num((x+0.5)*cell), num((y+0.5)*cell), symbol)
+			})
+			fmt.Fprintf(bw, "    </g>\n")
+		}
+
+		fmt.Fprintf(bw, "  </g>\n")
+	}
+
+	// Grid lines on top of the cells, as in the raster charts.
+	fmt.Fprintf(bw, "  <path id=\"grid\" fill=\"none\" stroke=\"#000000\" stroke-width=\"1\" d=\"")
+	for x := 0; x <= p.Width; x++ {
+		fmt.Fprintf(bw, "M%s 0V%s", num(float64(x)*cell), num(height))
+	}
+	for y := 0; y <= p.Height; y++ {
+		fmt.Fprintf(bw, "M0 %sH%s", num(float64(y)*cell), num(width))
+	}
+	fmt.Fprintf(bw, "\"/>\n")
+
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// forEachCell calls fn with the column and row of every cell using the
+// palette entry at index.
+func forEachCell(p *common.Pattern, index int, fn func(x, y float64)) {
+	for i, c := range p.Cells {
+		if c == index {
+			fn(float64(i%p.Width), float64(i/p.Width))
+		}
+	}
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// num formats a coordinate without trailing zeros.
+func num(v float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.3f", v), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
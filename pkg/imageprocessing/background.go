@@ -0,0 +1,162 @@
+package imageprocessing
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+)
+
+// DefaultBackgroundTolerance is the CIE76 difference from the background
+// color within which RemoveBackground clears pixels.
+const DefaultBackgroundTolerance = 10.0
+
+// minBackgroundShare is how much of the image's border the background color
+// must cover for RemoveBackground to treat it as a background.
+const minBackgroundShare = 0.25
+
+// BackgroundReport describes what RemoveBackground cleared.
+type BackgroundReport struct {
+	// Found is false when no color covers enough of the border to be a
+	// background, in which case nothing is cleared.
+	Found bool
+	// Color is the background color found along the border.
+	Color color.RGBA
+	// PixelsCleared counts the pixels made transparent.
+	PixelsCleared int
+}
+
+// RemoveBackground finds the dominant color along the border of img and
+// flood fills from the border through every pixel within tolerance of it in
+// Lab space, making the filled background transparent so it is left
+// unstitched. Only background connected to the border is cleared, so
+// similar colors inside the subject are kept. Run it before ResizeImage; the
+// input image is not modified.
+func RemoveBackground(img image.Image, tolerance float64) (image.Image, BackgroundReport) {
+	src := asRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	var report BackgroundReport
+	if width == 0 || height == 0 {
+		return img, report
+	}
+
+	labCache := make(map[color.RGBA]colormath.Lab)
+	lab := func(c color.RGBA) colormath.Lab {
+		l, ok := labCache[c]
+		if !ok {
+			l = colormath.RGBToLab(c.R, c.G, c.B)
+			labCache[c] = l
+		}
+		return l
+	}
+	offset := func(i int) int {
+		return (i/width)*src.Stride + (i%width)*4
+	}
+
+	// Opaque pixels around the border, each corner once.
+	var edge []int
+	for x := 0; x < width; x++ {
+		edge = append(edge, x)
+		if height > 1 {
+			edge = append(edge, (height-1)*width+x)
+		}
+	}
+	for y := 1; y < height-1; y++ {
+		edge = append(edge, y*width)
+		if width > 1 {
+			edge = append(edge, y*width+width-1)
+		}
+	}
+	var border []int
+	for _, i := range edge {
+		if !transparentAt(src.Pix, offset(i)) {
+			border = append(border, i)
+		}
+	}
+	if len(border) == 0 {
+		return img, report
+	}
+
+	// Start from the most common coarse color along the border, then
+	// average the border pixels close to it.
+	type bin struct {
+		count int
+		sum   colormath.Lab
+	}
+	bins := make(map[uint32]*bin)
+	var top *bin
+	for _, i := range border {
+		c := pixelAt(src.Pix, offset(i))
+		key := uint32(c.R>>4)<<8 | uint32(c.G>>4)<<4 | uint32(c.B>>4)
+		b, ok := bins[key]
+		if !ok {
+			b = &bin{}
+			bins[key] = b
+		}
+		l := lab(c)
+		b.count++
+		b.sum = colormath.Lab{L: b.sum.L + l.L, A: b.sum.A + l.A, B: b.sum.B + l.B}
+		if top == nil || b.count > top.count {
+			top = b
+		}
+	}
+	reference := colormath.Lab{L: top.sum.L / float64(top.count), A: top.sum.A / float64(top.count), B: top.sum.B / float64(top.count)}
+
+	var sum colormath.Lab
+	matched := 0
+	for _, i := range border {
+		if l := lab(pixelAt(src.Pix, offset(i))); colormath.CIE76(l, reference) <= tolerance {
+			sum = colormath.Lab{L: sum.L + l.L, A: sum.A + l.A, B: sum.B + l.B}
+			matched++
+		}
+	}
+	if float64(matched) < minBackgroundShare*float64(len(border)) {
+		return img, report
+	}
+	reference = colormath.Lab{L: sum.L / float64(matched), A: sum.A / float64(matched), B: sum.B / float64(matched)}
+	report.Found = true
+	report.Color = colormath.LabToRGB(reference)
+
+	isBackground := func(i int) bool {
+		o := offset(i)
+		return transparentAt(src.Pix, o) || colormath.CIE76(lab(pixelAt(src.Pix, o)), reference) <= tolerance
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		copy(dst.Pix[y*dst.Stride:(y+1)*dst.Stride], src.Pix[y*src.Stride:])
+	}
+
+	visited := make([]bool, width*height)
+	var stack []int
+	for _, i := range edge {
+		if !visited[i] && isBackground(i) {
+			visited[i] = true
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		o := i * 4
+		if !transparentAt(dst.Pix, o) {
+			dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = 0, 0, 0, 0
+			report.PixelsCleared++
+		}
+
+		x, y := i%width, i/width
+		for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+			if n[0] < 0 || n[0] >= width || n[1] < 0 || n[1] >= height {
+				continue
+			}
+			j := n[1]*width + n[0]
+			if !visited[j] && isBackground(j) {
+				visited[j] = true
+				stack = append(stack, j)
+			}
+		}
+	}
+
+	return dst, report
+}
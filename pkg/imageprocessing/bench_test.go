@@ -0,0 +1,201 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"runtime"
+	"sort"
+	"testing"
+
+	"golang.org/x/image/draw"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// benchWidth and benchHeight are the size of a large chart, in stitches.
+const benchWidth, benchHeight = 250, 188
+
+// benchPalette is how many threads the benchmark charts use.
+const benchPalette = 30
+
+// benchInput returns the example photo scaled to a chart's size, the DMC
+// library and a palette spread across it.
+func benchInput(tb testing.TB) (*image.RGBA, []common.ThreadColor, []common.ThreadColor) {
+	tb.Helper()
+	file, err := os.Open("../../lavender_field.jpeg")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	src, err := jpeg.Decode(file)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, benchWidth, benchHeight))
+	draw.CatmullRom.Scale(img, img.Rect, src, src.Bounds(), draw.Src, nil)
+
+	threadColors, err := LoadThreadColors("../../assets/thread_colors.txt")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	palette := make([]common.ThreadColor, 0, benchPalette)
+	for i := 0; i < benchPalette; i++ {
+		palette = append(palette, threadColors[i*len(threadColors)/benchPalette])
+	}
+	return img, threadColors, palette
+}
+
+// The reference functions are the pipeline as it was before it worked on
+// Pix slices: one goroutine reading pixels with At, writing them with Set
+// and matching every pixel through a cached matcher.
+
+func referenceReduceColors(img image.Image, palette []common.ThreadColor, metric colormath.Metric) image.Image {
+	matcher := colormath.NewMatcher(palette, metric)
+	bounds := img.Bounds()
+	reducedImg := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			reducedImg.Set(x, y, matcher.Nearest(img.At(x, y)).Color)
+		}
+	}
+	return reducedImg
+}
+
+func referencePopularityPalette(img image.Image, threadColors []common.ThreadColor, k int, metric colormath.Metric) []common.ThreadColor {
+	counts := make(map[common.ThreadColor]int)
+	matcher := colormath.NewMatcher(threadColors, metric)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			counts[matcher.Nearest(img.At(x, y))]++
+		}
+	}
+	selected := make([]common.ThreadColor, 0, len(counts))
+	for tc := range counts {
+		selected = append(selected, tc)
+	}
+	sort.Slice(selected, func(i, j int) bool { return counts[selected[i]] > counts[selected[j]] })
+	return selected[:min(k, len(selected))]
+}
+
+func referenceColorGrid(img image.Image, threadColors []common.ThreadColor) [][]common.ThreadColor {
+	matcher := colormath.NewMatcher(threadColors, colormath.MetricRGB)
+	bounds := img.Bounds()
+	grid := make([][]common.ThreadColor, bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := make([]common.ThreadColor, bounds.Dx())
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			row[x] = matcher.Nearest(img.At(x, y).(color.RGBA))
+		}
+		grid[y] = row
+	}
+	return grid
+}
+
+func TestPipelineMatchesReference(t *testing.T) {
+	img, _, palette := benchInput(t)
+	reduced := ReduceColors(img, palette, ReduceOptions{Metric: colormath.MetricRGB})
+	want := referenceReduceColors(img, palette, colormath.MetricRGB).(*image.RGBA)
+	// Library threads have no alpha, so the reference leaves its pixels
+	// transparent; only the colours have to agree.
+	got := asRGBA(reduced)
+	for i := 0; i < len(got.Pix); i += 4 {
+		if string(got.Pix[i:i+3]) != string(want.Pix[i:i+3]) {
+			t.Fatalf("ReduceColors differs from the reference at pixel %d", i/4)
+		}
+	}
+
+	grid := GenerateColorGrid(reduced, palette, true)
+	wantGrid := referenceColorGrid(want, palette)
+	for y := range grid {
+		for x := range grid[y] {
+			if grid[y][x] != wantGrid[y][x] {
+				t.Fatalf("GenerateColorGrid differs from the reference at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+// benchmarkStage runs reference as a "reference" sub-benchmark when it is
+// set, and current at GOMAXPROCS 1 and at the number of CPUs.
+func benchmarkStage(b *testing.B, reference, current func()) {
+	if reference != nil {
+		b.Run("reference", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				reference()
+			}
+		})
+	}
+	procs := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		procs = append(procs, n)
+	}
+	for _, p := range procs {
+		b.Run(fmt.Sprintf("procs=%d", p), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(p))
+			for i := 0; i < b.N; i++ {
+				current()
+			}
+		})
+	}
+}
+
+func BenchmarkGetPartialPalette(b *testing.B) {
+	img, threadColors, _ := benchInput(b)
+	for _, metric := range []colormath.Metric{colormath.MetricRGB, colormath.MetricCIEDE2000} {
+		b.Run(metric.String(), func(b *testing.B) {
+			benchmarkStage(b, func() {
+				referencePopularityPalette(img, threadColors, benchPalette, metric)
+			}, func() {
+				GetPartialPalette(img, threadColors, PaletteOptions{NumColors: benchPalette, Metric: metric})
+			})
+		})
+	}
+}
+
+func BenchmarkReduceColors(b *testing.B) {
+	img, _, palette := benchInput(b)
+	for _, metric := range []colormath.Metric{colormath.MetricRGB, colormath.MetricCIEDE2000} {
+		b.Run(metric.String(), func(b *testing.B) {
+			benchmarkStage(b, func() {
+				referenceReduceColors(img, palette, metric)
+			}, func() {
+				ReduceColors(img, palette, ReduceOptions{Metric: metric})
+			})
+		})
+	}
+}
+
+func BenchmarkGenerateColorGrid(b *testing.B) {
+	img, _, palette := benchInput(b)
+	reduced := ReduceColors(img, palette, ReduceOptions{Metric: colormath.MetricRGB})
+	plain := referenceReduceColors(img, palette, colormath.MetricRGB)
+	benchmarkStage(b, func() {
+		referenceColorGrid(plain, palette)
+	}, func() {
+		GenerateColorGrid(reduced, palette, true)
+	})
+}
+
+func BenchmarkDither(b *testing.B) {
+	img, _, palette := benchInput(b)
+	for _, mode := range []DitherMode{DitherFloydSteinberg, DitherBayer} {
+		b.Run(mode.String(), func(b *testing.B) {
+			benchmarkStage(b, nil, func() {
+				ReduceColors(img, palette, ReduceOptions{Metric: colormath.MetricRGB, Dither: mode, DitherStrength: 1})
+			})
+		})
+	}
+}
+
+func BenchmarkRemoveConfetti(b *testing.B) {
+	img, _, palette := benchInput(b)
+	reduced := ReduceColors(img, palette, ReduceOptions{Metric: colormath.MetricRGB})
+	benchmarkStage(b, nil, func() {
+		RemoveConfetti(reduced, 4, colormath.MetricRGB)
+	})
+}
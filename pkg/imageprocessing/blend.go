@@ -0,0 +1,99 @@
+package imageprocessing
+
+import (
+	"image"
+	"math"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/threads"
+)
+
+// maxBlendDistance is the largest CIE76 difference between two threads
+// that are blended. Blends of very different colors read as speckles
+// rather than as a new color.
+const maxBlendDistance = 25.0
+
+// blendPalette returns up to n blended threads made from pairs of palette
+// threads. Blends are picked greedily by how much closer they bring the
+// image's colors than the palette already does, so only blends that fill a
+// real gap between two threads are added. Each gets an unused symbol.
+func blendPalette(img image.Image, palette []common.ThreadColor, n int) []common.ThreadColor {
+	if n <= 0 || len(palette) < 2 {
+		return nil
+	}
+
+	hist := colorHistogram(img)
+	pixels := make([]colormath.Lab, 0, len(hist))
+	weights := make([]float64, 0, len(hist))
+	for c, count := range hist {
+		pixels = append(pixels, colormath.RGBToLab(c.R, c.G, c.B))
+		weights = append(weights, float64(count))
+	}
+
+	paletteLab := make([]colormath.Lab, len(palette))
+	for i, tc := range palette {
+		paletteLab[i] = colormath.RGBToLab(tc.Color.R, tc.Color.G, tc.Color.B)
+	}
+
+	// Distance from each image color to the nearest entry so far.
+	nearest := make([]float64, len(pixels))
+	for i, p := range pixels {
+		nearest[i] = math.MaxFloat64
+		for _, lab := range paletteLab {
+			nearest[i] = math.Min(nearest[i], colormath.CIE76(p, lab))
+		}
+	}
+
+	type candidate struct {
+		blend common.ThreadColor
+		lab   colormath.Lab
+	}
+	var candidates []candidate
+	for i := range palette {
+		for j := i + 1; j < len(palette); j++ {
+			if palette[i].IsBlend() || palette[j].IsBlend() {
+				continue
+			}
+			if colormath.CIE76(paletteLab[i], paletteLab[j]) > maxBlendDistance {
+				continue
+			}
+			blend := threads.NewBlend(palette[i], palette[j])
+			candidates = append(candidates, candidate{blend: blend, lab: colormath.RGBToLab(blend.Color.R, blend.Color.G, blend.Color.B)})
+		}
+	}
+
+	var blends []common.ThreadColor
+	for len(blends) < n && len(candidates) > 0 {
+		best, bestGain := -1, 0.0
+		for c, cand := range candidates {
+			gain := 0.0
+			for i, p := range pixels {
+				if d := colormath.CIE76(p, cand.lab); d < nearest[i] {
+					gain += weights[i] * (nearest[i] - d)
+				}
+			}
+			if gain > bestGain {
+				best, bestGain = c, gain
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		chosen := candidates[best]
+		for i, p := range pixels {
+			nearest[i] = math.Min(nearest[i], colormath.CIE76(p, chosen.lab))
+		}
+		blends = append(blends, chosen.blend)
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+
+	symbols := threads.UnusedSymbols(palette, len(blends))
+	for i := range blends {
+		if i < len(symbols) {
+			blends[i].Symbol = symbols[i]
+		}
+	}
+	return blends
+}
@@ -0,0 +1,245 @@
+package imageprocessing
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+)
+
+// CleanupReport summarizes what RemoveConfetti changed.
+type CleanupReport struct {
+	// RegionsMerged counts the regions merged into a neighbour, each once.
+	RegionsMerged int
+	// CellsChanged counts the cells whose color differs from the input.
+	CellsChanged int
+}
+
+// maxCleanupPasses bounds how often RemoveConfetti relabels the image; merged
+// regions can themselves still be too small after a pass.
+const maxCleanupPasses = 10
+
+// RemoveConfetti merges every 4-connected region of one color with fewer
+// than minRegionSize cells into the most similar neighbouring color under
+// metric. A minRegionSize of 2 removes isolated single stitches. Empty
+// cells are neither merged nor merged into. The input image is not modified.
+//
+// An *IndexedImage, such as ReduceColors returns, is cleaned by palette
+// index and the result is an *IndexedImage of the same palette, so threads
+// that share a color, such as a blend that mixes to another thread's color,
+// stay apart. Other images are cleaned by color.
+func RemoveConfetti(img image.Image, minRegionSize int, metric colormath.Metric) (image.Image, CleanupReport) {
+	rgba := asRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+
+	// Cells hold a label per pixel, or -1 when empty, and colors the color
+	// of each label: the palette index of an indexed image, otherwise one
+	// label per distinct color.
+	var cells []int
+	var colors []color.RGBA
+	indexed, isIndexed := img.(*IndexedImage)
+	if isIndexed {
+		cells = make([]int, len(indexed.Indices))
+		copy(cells, indexed.Indices)
+		colors = make([]color.RGBA, len(indexed.Palette))
+		for i, tc := range indexed.Palette {
+			colors[i] = color.RGBA{R: tc.Color.R, G: tc.Color.G, B: tc.Color.B, A: 255}
+		}
+	} else {
+		cells = make([]int, width*height)
+		labels := make(map[color.RGBA]int)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*rgba.Stride + x*4
+				if transparentAt(rgba.Pix, i) {
+					cells[y*width+x] = -1
+					continue
+				}
+				c := pixelAt(rgba.Pix, i)
+				label, ok := labels[c]
+				if !ok {
+					label = len(colors)
+					labels[c] = label
+					colors = append(colors, c)
+				}
+				cells[y*width+x] = label
+			}
+		}
+	}
+	original := make([]int, len(cells))
+	copy(original, cells)
+
+	var report CleanupReport
+	labs := make([]colormath.Lab, len(colors))
+	if metric.UsesLab() {
+		for i, c := range colors {
+			labs[i] = colormath.RGBToLab(c.R, c.G, c.B)
+		}
+	}
+	distance := func(a, b int) float64 {
+		if !metric.UsesLab() {
+			ca, cb := colors[a], colors[b]
+			dr, dg, db := float64(ca.R)-float64(cb.R), float64(ca.G)-float64(cb.G), float64(ca.B)-float64(cb.B)
+			return math.Sqrt(dr*dr + dg*dg + db*db)
+		}
+		return metric.LabDistance(labs[a], labs[b])
+	}
+
+	for pass := 0; pass < maxCleanupPasses; pass++ {
+		regions := labelRegions(cells, width, height)
+		sort.SliceStable(regions, func(i, j int) bool { return len(regions[i]) < len(regions[j]) })
+
+		// A region that another merged into this pass is larger than its
+		// label says; it waits for the next pass, when it is labelled
+		// again, instead of merging away and leaving its neighbour
+		// isolated.
+		regionOf := make([]int, len(cells))
+		for r, region := range regions {
+			for _, i := range region {
+				regionOf[i] = r
+			}
+		}
+		grown := make([]bool, len(regions))
+
+		merged := 0
+		for r, region := range regions {
+			if len(region) >= minRegionSize {
+				break
+			}
+			regionLabel := cells[region[0]]
+			if regionLabel < 0 || grown[r] {
+				continue
+			}
+
+			// Count how much border the region shares with each label.
+			border := make(map[int]int)
+			var neighbours []int
+			for _, i := range region {
+				x, y := i%width, i/width
+				for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+					if n[0] < 0 || n[0] >= width || n[1] < 0 || n[1] >= height {
+						continue
+					}
+					j := n[1]*width + n[0]
+					if l := cells[j]; l != regionLabel && l >= 0 {
+						border[l]++
+						neighbours = append(neighbours, j)
+					}
+				}
+			}
+			if len(border) == 0 {
+				continue
+			}
+
+			target := -1
+			bestDistance, bestBorder := math.MaxFloat64, 0
+			for l, shared := range border {
+				d := distance(regionLabel, l)
+				if d < bestDistance || (d == bestDistance && (shared > bestBorder ||
+					(shared == bestBorder && labelLess(colors, l, target)))) {
+					target, bestDistance, bestBorder = l, d, shared
+				}
+			}
+
+			for _, i := range region {
+				cells[i] = target
+			}
+			for _, j := range neighbours {
+				if cells[j] == target {
+					grown[regionOf[j]] = true
+				}
+			}
+			merged++
+		}
+
+		report.RegionsMerged += merged
+		if merged == 0 {
+			break
+		}
+	}
+
+	for i, l := range cells {
+		if l != original[i] {
+			report.CellsChanged++
+		}
+	}
+	if isIndexed {
+		return newIndexedImage(width, height, indexed.Palette, cells), report
+	}
+
+	cleanedImg := image.NewRGBA(rgba.Rect)
+	for i, l := range cells {
+		if l >= 0 {
+			c := colors[l]
+			cleanedImg.Pix[i*4], cleanedImg.Pix[i*4+1], cleanedImg.Pix[i*4+2], cleanedImg.Pix[i*4+3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return cleanedImg, report
+}
+
+// labelRegions splits cells into 4-connected regions of one label,
+// returning the cell indices of each region.
+func labelRegions(cells []int, width, height int) [][]int {
+	visited := make([]bool, len(cells))
+	var regions [][]int
+	var stack []int
+
+	for start := range cells {
+		if visited[start] {
+			continue
+		}
+		regionLabel := cells[start]
+		var region []int
+		visited[start] = true
+		stack = append(stack[:0], start)
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			region = append(region, i)
+
+			x, y := i%width, i/width
+			if x > 0 && !visited[i-1] && cells[i-1] == regionLabel {
+				visited[i-1] = true
+				stack = append(stack, i-1)
+			}
+			if x < width-1 && !visited[i+1] && cells[i+1] == regionLabel {
+				visited[i+1] = true
+				stack = append(stack, i+1)
+			}
+			if y > 0 && !visited[i-width] && cells[i-width] == regionLabel {
+				visited[i-width] = true
+				stack = append(stack, i-width)
+			}
+			if y < height-1 && !visited[i+width] && cells[i+width] == regionLabel {
+				visited[i+width] = true
+				stack = append(stack, i+width)
+			}
+		}
+		regions = append(regions, region)
+	}
+
+	return regions
+}
+
+// labelLess orders labels by color, then by label, so ties between
+// neighbours are broken the same way on every run. Any label sorts before
+// -1, which stands for none.
+func labelLess(colors []color.RGBA, a, b int) bool {
+	if b < 0 {
+		return true
+	}
+	ca, cb := colors[a], colors[b]
+	if ca.R != cb.R {
+		return ca.R < cb.R
+	}
+	if ca.G != cb.G {
+		return ca.G < cb.G
+	}
+	if ca.B != cb.B {
+		return ca.B < cb.B
+	}
+	return a < b
+}
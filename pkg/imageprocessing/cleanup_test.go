@@ -0,0 +1,70 @@
+package imageprocessing
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// TestRemoveConfettiKeepsThreadsSharingAColor checks that cleanup keeps a
+// blend apart from a thread of the same color, so the grid still knows
+// which cells are the blend.
+func TestRemoveConfettiKeepsThreadsSharingAColor(t *testing.T) {
+	gray := color.RGBA{R: 128, G: 128, B: 128}
+	palette := []common.ThreadColor{
+		{ID: 415, Name: "Pearl Gray", Color: gray},
+		{Name: "Black + White", Color: gray, Blend: [2]common.Strand{{ID: 310, Name: "Black"}, {ID: 5200, Name: "White"}}},
+		{ID: 321, Name: "Red", Color: color.RGBA{R: 199, G: 43, B: 59}},
+	}
+	// A lone red stitch inside blend cells, next to a column of gray.
+	indices := []int{
+		0, 1, 1, 1,
+		0, 1, 2, 1,
+		0, 1, 1, 1,
+	}
+	img := newIndexedImage(4, 3, palette, indices)
+
+	cleaned, report := RemoveConfetti(img, 2, colormath.MetricRGB)
+	if report.RegionsMerged != 1 || report.CellsChanged != 1 {
+		t.Errorf("report = %+v, want 1 region and 1 cell", report)
+	}
+	grid := GenerateColorGrid(cleaned, palette, true)
+	for y, row := range grid {
+		for x, tc := range row {
+			want := palette[1]
+			if x == 0 {
+				want = palette[0]
+			}
+			if tc != want {
+				t.Errorf("cell (%d, %d) = %s, want %s", x, y, tc.Name, want.Name)
+			}
+		}
+	}
+}
+
+func TestRemoveConfettiMergesEachRegionOnce(t *testing.T) {
+	palette := make([]common.ThreadColor, 6)
+	for i := range palette {
+		palette[i] = common.ThreadColor{ID: i + 1, Color: color.RGBA{R: uint8(i * 40), G: uint8(255 - i*40), B: 128}}
+	}
+	const width, height, minRegionSize = 40, 30, 4
+	indices := make([]int, width*height)
+	seed := uint32(1)
+	for i := range indices {
+		seed = seed*1664525 + 1013904223
+		indices[i] = int(seed>>16) % len(palette)
+	}
+	img := newIndexedImage(width, height, palette, indices)
+
+	cleaned, report := RemoveConfetti(img, minRegionSize, colormath.MetricRGB)
+	if report.RegionsMerged == 0 || report.RegionsMerged > report.CellsChanged {
+		t.Errorf("report = %+v, want at least one region merged and no more regions than cells changed", report)
+	}
+	for _, region := range labelRegions(cleaned.(*IndexedImage).Indices, width, height) {
+		if len(region) < minRegionSize {
+			t.Errorf("region of %d cells at %d is left after cleanup", len(region), region[0])
+		}
+	}
+}
@@ -1,116 +1,42 @@
 package imageprocessing
 
 import (
-	"bufio"
 	"image"
-	"image/color"
-	"log"
-	"os"
-	"strconv"
-	"strings"
+	"math"
 
 	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
 	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/threads"
 )
 
-func createUnicodeCharMap(threadColors []common.ThreadColor) map[int]rune {
-	unicodeMap := make(map[int]rune)
-	mapIdx := 0
-	threadColorsLength := len(threadColors)
-
-	ranges := [][3]int{
-		{0x2190, 0x21FF}, // Arrows: U+2190 to U+21FF
-		{0x2200, 0x22FF}, // Mathematical Operators: U+2200 to U+22FF
-		{0x2500, 0x257F}, // Box Drawing: U+2500 to U+257F
-	}
-
-	for _, r := range ranges {
-		for i := r[0]; i <= r[1]; i++ {
-			if mapIdx == threadColorsLength {
-				break
-			}
-			unicodeMap[threadColors[mapIdx].ID] = rune(i)
-			mapIdx += 1
-		}
-	}
-
-	return unicodeMap
-}
-
-func ColorAtoi(s string) uint8 {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		log.Printf("error converting string to int: %s", err)
-		return 0
-	}
-	return uint8(i)
-}
-
+// LoadThreadColors reads a thread palette file in any format
+// threads.LoadPalette supports.
 func LoadThreadColors(filePath string) ([]common.ThreadColor, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Fatalf("failed to open file: %s", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	var threadImg []common.ThreadColor
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Find the last space before the integer
-		parts := strings.FieldsFunc(line, func(r rune) bool {
-			return r == '\t'
-		})
-
-		var name string
-		var id int
-		lineIdx := len(parts) - 3
-
-		id, err = strconv.Atoi(parts[0])
-		if err != nil {
-			log.Fatal(err)
-		}
-		name = strings.Join(parts[1:lineIdx], " ")
-
-		ThreadColor := common.ThreadColor{
-			ID:    id,
-			Name:  name,
-			Color: color.RGBA{R: ColorAtoi(parts[lineIdx-1]), G: ColorAtoi(parts[lineIdx]), B: ColorAtoi(parts[lineIdx+1])},
-		}
-
-		threadImg = append(threadImg, ThreadColor)
-	}
-
-	dmcMap := createUnicodeCharMap(threadImg)
-	for i := range threadImg {
-		threadImg[i].Symbol = string(dmcMap[threadImg[i].ID])
-	}
-
-	// dmcMapLength := len(dmcMap)
-
-	// for i := 0; i <= dmcMapLength; i++ {
-	// 	threadImg[i].Symbol = string(dmcMap[i])
-	// }
-
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("error reading file: %s", err)
-	}
-	return threadImg, err
+	return threads.LoadPalette(filePath)
 }
 
-func ReduceColors(img image.Image, palette []common.ThreadColor) image.Image {
-	bounds := img.Bounds()
-	reducedImg := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y += 1 {
-		for x := bounds.Min.X; x < bounds.Max.X; x += 1 {
-			originalColor := img.At(x, y)
-			nearestColor := colormath.NearestColor(originalColor, palette)
-			reducedImg.Set(x, y, nearestColor.Color)
-		}
-	}
+// ReduceOptions controls how ReduceColors maps pixels onto the palette.
+type ReduceOptions struct {
+	Metric colormath.Metric
+	Dither DitherMode
+	// DitherStrength scales the dithering error from 0 to 1.
+	DitherStrength float64
+}
 
-	return reducedImg
+// ReduceColors replaces every pixel with a palette color, optionally
+// dithering so gradients are approximated by mixing palette threads. The
+// result is an *IndexedImage, which GenerateColorGrid reads without
+// matching colors again.
+func ReduceColors(img image.Image, palette []common.ThreadColor, opts ReduceOptions) image.Image {
+	rgba := asRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+
+	var indices []int
+	if opts.Dither != DitherNone && opts.DitherStrength > 0 {
+		matcher := colormath.NewIndexMatcher(palette, opts.Metric)
+		indices = ditherImage(rgba, palette, matcher, opts.Dither, math.Min(opts.DitherStrength, 1))
+	} else {
+		indices = paletteIndices(rgba, palette, opts.Metric)
+	}
+	return newIndexedImage(width, height, palette, indices)
 }
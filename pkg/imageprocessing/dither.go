@@ -0,0 +1,194 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// DitherMode selects how ReduceColors spreads quantization error.
+type DitherMode int
+
+const (
+	DitherNone DitherMode = iota
+	DitherFloydSteinberg
+	DitherAtkinson
+	DitherJarvisJudiceNinke
+	DitherSierra
+	DitherBayer
+)
+
+// DitherModes lists every dithering mode, in display order.
+var DitherModes = []DitherMode{DitherNone, DitherFloydSteinberg, DitherAtkinson, DitherJarvisJudiceNinke, DitherSierra, DitherBayer}
+
+func (d DitherMode) String() string {
+	switch d {
+	case DitherNone:
+		return "None"
+	case DitherFloydSteinberg:
+		return "Floyd-Steinberg"
+	case DitherAtkinson:
+		return "Atkinson"
+	case DitherJarvisJudiceNinke:
+		return "Jarvis-Judice-Ninke"
+	case DitherSierra:
+		return "Sierra"
+	case DitherBayer:
+		return "Bayer"
+	}
+	return fmt.Sprintf("DitherMode(%d)", int(d))
+}
+
+// ParseDitherMode returns the mode whose name matches s, ignoring case.
+func ParseDitherMode(s string) (DitherMode, error) {
+	for _, d := range DitherModes {
+		if strings.EqualFold(d.String(), s) {
+			return d, nil
+		}
+	}
+	return DitherNone, fmt.Errorf("unknown dither mode: %s", s)
+}
+
+// diffusionWeight sends a share of a pixel's error to the neighbour at
+// (x+DX, y+DY).
+type diffusionWeight struct {
+	DX, DY int
+	Weight float64
+}
+
+// diffusionKernels holds the error diffusion matrices. Atkinson deliberately
+// diffuses only 6/8 of the error.
+var diffusionKernels = map[DitherMode][]diffusionWeight{
+	DitherFloydSteinberg: {
+		{1, 0, 7.0 / 16},
+		{-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	DitherAtkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+	DitherJarvisJudiceNinke: {
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	},
+	DitherSierra: {
+		{1, 0, 5.0 / 32}, {2, 0, 3.0 / 32},
+		{-2, 1, 2.0 / 32}, {-1, 1, 4.0 / 32}, {0, 1, 5.0 / 32}, {1, 1, 4.0 / 32}, {2, 1, 2.0 / 32},
+		{-1, 2, 2.0 / 32}, {0, 2, 3.0 / 32}, {1, 2, 2.0 / 32},
+	},
+}
+
+// bayer8 is the 8x8 Bayer threshold matrix.
+var bayer8 = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// bayerSpread is the channel offset range, at full strength, that ordered
+// dithering adds before matching.
+const bayerSpread = 64.0
+
+func clampChannel(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(255, v))))
+}
+
+// ditherImage reduces img to the palette, diffusing or ordering
+// quantization error according to mode, and returns the palette index of
+// each pixel, or -1 for a transparent one. strength scales the error from
+// 0 (plain nearest color) to 1 (the full kernel). Ordered dithering works
+// on bands of rows in parallel; error diffusion carries error from row to
+// row and so runs on one goroutine.
+func ditherImage(img *image.RGBA, palette []common.ThreadColor, matcher colormath.IndexMatcher, mode DitherMode, strength float64) []int {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	indices := make([]int, width*height)
+
+	// nearest memoizes the matcher per goroutine.
+	nearest := func(cache map[color.RGBA]int, c color.RGBA) int {
+		i, ok := cache[c]
+		if !ok {
+			i = matcher.NearestIndex(c)
+			cache[c] = i
+		}
+		return i
+	}
+
+	if mode == DitherBayer {
+		parallelRange(height, func(y0, y1 int) {
+			cache := make(map[color.RGBA]int)
+			for y := y0; y < y1; y++ {
+				row := y * img.Stride
+				for x := 0; x < width; x++ {
+					if transparentAt(img.Pix, row+x*4) {
+						indices[y*width+x] = -1
+						continue
+					}
+					p := pixelAt(img.Pix, row+x*4)
+					offset := (bayer8[y%8][x%8]/64 - 0.5) * bayerSpread * strength
+					c := color.RGBA{
+						R: clampChannel(float64(p.R) + offset),
+						G: clampChannel(float64(p.G) + offset),
+						B: clampChannel(float64(p.B) + offset),
+						A: 255,
+					}
+					indices[y*width+x] = nearest(cache, c)
+				}
+			}
+		})
+		return indices
+	}
+
+	kernel := diffusionKernels[mode]
+	errs := make([][3]float64, width*height)
+	cache := make(map[color.RGBA]int)
+
+	for y := 0; y < height; y++ {
+		row := y * img.Stride
+		for x := 0; x < width; x++ {
+			if transparentAt(img.Pix, row+x*4) {
+				indices[y*width+x] = -1
+				continue
+			}
+			p := pixelAt(img.Pix, row+x*4)
+			e := errs[y*width+x]
+			want := [3]float64{float64(p.R) + e[0], float64(p.G) + e[1], float64(p.B) + e[2]}
+
+			k := nearest(cache, color.RGBA{R: clampChannel(want[0]), G: clampChannel(want[1]), B: clampChannel(want[2]), A: 255})
+			indices[y*width+x] = k
+			if k < 0 {
+				continue
+			}
+			got := palette[k].Color
+
+			diff := [3]float64{
+				(want[0] - float64(got.R)) * strength,
+				(want[1] - float64(got.G)) * strength,
+				(want[2] - float64(got.B)) * strength,
+			}
+			for _, w := range kernel {
+				nx, ny := x+w.DX, y+w.DY
+				if nx < 0 || nx >= width || ny >= height {
+					continue
+				}
+				i := ny*width + nx
+				errs[i][0] += diff[0] * w.Weight
+				errs[i][1] += diff[1] * w.Weight
+				errs[i][2] += diff[2] * w.Weight
+			}
+		}
+	}
+
+	return indices
+}
@@ -26,25 +26,36 @@ func GenerateGrid(img image.Image, threadColors []common.ThreadColor) [][]string
 	return grid
 }
 
+// GenerateColorGrid returns the thread of every pixel of img. With
+// getNearestColor each pixel is matched to its nearest thread, reusing the
+// palette indices of an image from ReduceColors or RemoveConfetti with
+// the same threads; otherwise each cell just carries the pixel's color.
+// Transparent pixels are left as the zero ThreadColor, an empty cell.
 func GenerateColorGrid(img image.Image, threadColors []common.ThreadColor, getNearestColor bool) [][]common.ThreadColor {
-	bounds := img.Bounds()
-	grid := make([][]common.ThreadColor, bounds.Dy())
+	rgba := asRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	grid := make([][]common.ThreadColor, height)
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		row := make([]common.ThreadColor, bounds.Dx())
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			color := img.At(x, y).(color.RGBA)
+	var indices []int
+	if getNearestColor {
+		indices = indexedFor(img, threadColors, colormath.MetricRGB)
+	}
 
-			if getNearestColor {
-				row[x] = colormath.NearestColor(color, threadColors)
-			} else {
-				row[x] = common.ThreadColor{ // symbol necessary here?
-					Color: color,
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := make([]common.ThreadColor, width)
+			for x := 0; x < width; x++ {
+				if !getNearestColor {
+					if i := y*rgba.Stride + x*4; !transparentAt(rgba.Pix, i) {
+						row[x] = common.ThreadColor{Color: pixelAt(rgba.Pix, i)}
+					}
+				} else if k := indices[y*width+x]; k >= 0 {
+					row[x] = threadColors[k]
 				}
 			}
+			grid[y] = row
 		}
-		grid[y] = row
-	}
+	})
 
 	return grid
 }
@@ -67,37 +78,67 @@ func findThreadColorName(c color.RGBA, threadColors []common.ThreadColor) string
 // 	return colormath.NearestColor(c, threadColors)
 // }
 
-func GetPartialPalette(img image.Image, threadColors []common.ThreadColor, k int) []common.ThreadColor {
-	colorCounts := make(map[common.ThreadColor]int)
-	bounds := img.Bounds()
+// PaletteOptions controls how GetPartialPalette chooses a chart's threads.
+type PaletteOptions struct {
+	NumColors int
+	Method    QuantizeMethod
+	Metric    colormath.Metric
+	// Blends is the most blended threads, each one strand of two palette
+	// threads, to add on top of the NumColors real threads. Zero adds none.
+	Blends int
+}
 
-	// Count the occurrence of each color in the image
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.At(x, y)
-			threadColor := colormath.NearestColor(c, threadColors)
-			colorCounts[threadColor]++
+// GetPartialPalette returns up to opts.NumColors distinct thread colors that
+// represent the image, followed by up to opts.Blends blended threads. Every
+// method except QuantizePopularity quantizes the image first and then snaps
+// each cluster center to its nearest unused thread.
+func GetPartialPalette(img image.Image, threadColors []common.ThreadColor, opts PaletteOptions) []common.ThreadColor {
+	var selected []common.ThreadColor
+	var centers []weightedColor
+	switch opts.Method {
+	case QuantizeMedianCut:
+		centers = medianCutColors(img, opts.NumColors)
+	case QuantizeKMeans:
+		centers = kMeansColors(img, opts.NumColors)
+	case QuantizeOctree:
+		centers = octreeColors(img, opts.NumColors)
+	default:
+		selected = popularityPalette(img, threadColors, opts.NumColors, opts.Metric)
+	}
+
+	if centers != nil {
+		selected = snapToThreads(centers, threadColors, opts.Metric)
+		if len(selected) > opts.NumColors {
+			selected = selected[:opts.NumColors]
 		}
 	}
+	return append(selected, blendPalette(img, selected, opts.Blends)...)
+}
 
-	// Create a slice of thread colors and sort by count
-	type colorCountPair struct {
-		Color common.ThreadColor
-		Count int
+// popularityPalette keeps the k threads that are nearest to the most pixels.
+func popularityPalette(img image.Image, threadColors []common.ThreadColor, k int, metric colormath.Metric) []common.ThreadColor {
+	counts := make([]int, len(threadColors))
+	for _, i := range paletteIndices(asRGBA(img), threadColors, metric) {
+		if i >= 0 {
+			counts[i]++
+		}
 	}
 
-	var sortedColors []colorCountPair
-	for color, count := range colorCounts {
-		sortedColors = append(sortedColors, colorCountPair{Color: color, Count: count})
+	// Sort the used threads by count
+	var order []int
+	for i, n := range counts {
+		if n > 0 {
+			order = append(order, i)
+		}
 	}
-	sort.Slice(sortedColors, func(i, j int) bool {
-		return sortedColors[i].Count > sortedColors[j].Count
+	sort.SliceStable(order, func(a, b int) bool {
+		return counts[order[a]] > counts[order[b]]
 	})
 
 	// Select up to k colors
 	var selectedColors []common.ThreadColor
-	for i := 0; i < len(sortedColors) && i < k; i++ {
-		selectedColors = append(selectedColors, sortedColors[i].Color)
+	for i := 0; i < len(order) && i < k; i++ {
+		selectedColors = append(selectedColors, threadColors[order[i]])
 	}
 
 	return selectedColors
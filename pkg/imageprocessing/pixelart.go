@@ -0,0 +1,167 @@
+package imageprocessing
+
+import (
+	"image"
+	"sync"
+)
+
+// pixelArtTolerance is the largest channel difference between two pixels
+// of the same native pixel, allowing for rounding by image editors.
+const pixelArtTolerance = 4
+
+// PixelGrid is the grid of native pixels in upscaled pixel art: every Cell
+// sized block, counted from Origin, is one native pixel. Blocks cut off by
+// the image's edges are native pixels too.
+type PixelGrid struct {
+	Origin image.Point
+	Cell   image.Point
+}
+
+// Size returns how many native pixels across and down an image with the
+// given bounds holds.
+func (g PixelGrid) Size(bounds image.Rectangle) (width, height int) {
+	return gridCells(bounds.Dx(), g.Origin.X, g.Cell.X), gridCells(bounds.Dy(), g.Origin.Y, g.Cell.Y)
+}
+
+// gridCells counts the cells of size cell, aligned to origin, that cover n
+// pixels.
+func gridCells(n, origin, cell int) int {
+	if cell <= 1 {
+		return n
+	}
+	cells := (n - origin + cell - 1) / cell
+	if origin > 0 {
+		cells++
+	}
+	return cells
+}
+
+// DetectPixelGrid finds the grid of an image made by scaling up pixel art
+// by whole numbers. It looks for the columns and rows where the color
+// changes and takes the largest spacing that all of them fall on. An image
+// at its native size, or one that is not pixel art, gets a grid of 1 by 1
+// cells.
+func DetectPixelGrid(img image.Image) PixelGrid {
+	src := asRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// columns[x] is set when pixel x differs from pixel x-1 in some row,
+	// rows[y] when row y differs from row y-1 in some column.
+	columns := make([]bool, width)
+	rows := make([]bool, height)
+	var mu sync.Mutex
+	parallelRange(height, func(y0, y1 int) {
+		bandColumns := make([]bool, width)
+		for y := y0; y < y1; y++ {
+			row := y * src.Stride
+			for x := 1; x < width; x++ {
+				if !bandColumns[x] && !samePixel(src.Pix, row+x*4-4, row+x*4) {
+					bandColumns[x] = true
+				}
+			}
+			if y == 0 {
+				continue
+			}
+			for x := 0; x < width; x++ {
+				if !samePixel(src.Pix, row-src.Stride+x*4, row+x*4) {
+					rows[y] = true
+					break
+				}
+			}
+		}
+		mu.Lock()
+		for x, changed := range bandColumns {
+			columns[x] = columns[x] || changed
+		}
+		mu.Unlock()
+	})
+
+	firstX, cellX := gridAxis(columns)
+	firstY, cellY := gridAxis(rows)
+	// With fewer than two boundaries along one axis, assume square native
+	// pixels.
+	switch {
+	case cellX == 0 && cellY == 0:
+		cellX, cellY = 1, 1
+	case cellX == 0:
+		cellX = cellY
+	case cellY == 0:
+		cellY = cellX
+	}
+	return PixelGrid{Origin: image.Pt(firstX%cellX, firstY%cellY), Cell: image.Pt(cellX, cellY)}
+}
+
+// gridAxis returns the first boundary and the spacing of the largest grid
+// every boundary falls on. The spacing is zero when there are fewer than two
+// boundaries to tell it from.
+func gridAxis(boundaries []bool) (first, cell int) {
+	last := -1
+	for i, b := range boundaries {
+		if !b {
+			continue
+		}
+		if last < 0 {
+			first = i
+		} else {
+			cell = gcd(cell, i-last)
+		}
+		last = i
+	}
+	return first, cell
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// samePixel reports whether the pixels at byte offsets i and j of pix are
+// within pixelArtTolerance of each other in every channel.
+func samePixel(pix []uint8, i, j int) bool {
+	for k := 0; k < 4; k++ {
+		d := int(pix[i+k]) - int(pix[j+k])
+		if d < -pixelArtTolerance || d > pixelArtTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// SamplePixelGrid returns the native pixel art of img, one pixel per cell
+// of grid, taking each cell's center pixel.
+func SamplePixelGrid(img image.Image, grid PixelGrid) image.Image {
+	if grid.Cell.X <= 1 && grid.Cell.Y <= 1 {
+		return img
+	}
+	src := asRGBA(img)
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	width, height := grid.Size(src.Rect)
+
+	// center returns the middle source pixel of native pixel i along an
+	// axis, within the part of the cell inside the image.
+	center := func(i, origin, cell, n int) int {
+		start := origin + (i-1)*cell
+		if origin == 0 {
+			start = i * cell
+		}
+		end := min(start+cell, n)
+		start = max(start, 0)
+		return (start + end - 1) / 2
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := center(y, grid.Origin.Y, grid.Cell.Y, srcHeight)
+			for x := 0; x < width; x++ {
+				sx := center(x, grid.Origin.X, grid.Cell.X, srcWidth)
+				i := sy*src.Stride + sx*4
+				j := y*dst.Stride + x*4
+				copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+			}
+		}
+	})
+	return dst
+}
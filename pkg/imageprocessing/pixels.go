@@ -0,0 +1,194 @@
+package imageprocessing
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"runtime"
+	"sync"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// asRGBA returns img as an *image.RGBA whose bounds start at the origin,
+// copying it only when it is another kind of image.
+func asRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	if indexed, ok := img.(*IndexedImage); ok {
+		return asRGBA(indexed.RGBA)
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// pixelAt returns the color of the pixel at byte offset i of pix, with
+// premultiplied alpha undone, as an opaque color.
+func pixelAt(pix []uint8, i int) color.RGBA {
+	a := uint32(pix[i+3])
+	if a == 0 || a == 255 {
+		return color.RGBA{R: pix[i], G: pix[i+1], B: pix[i+2], A: 255}
+	}
+	return color.RGBA{
+		R: uint8(uint32(pix[i]) * 255 / a),
+		G: uint8(uint32(pix[i+1]) * 255 / a),
+		B: uint8(uint32(pix[i+2]) * 255 / a),
+		A: 255,
+	}
+}
+
+// transparentAt reports whether the pixel at byte offset i of pix is fully
+// transparent. Such pixels are left unstitched.
+func transparentAt(pix []uint8, i int) bool {
+	return pix[i+3] == 0
+}
+
+// DefaultAlphaThreshold is the alpha below which ClearTransparent leaves a
+// pixel unstitched: anything more than half transparent.
+const DefaultAlphaThreshold = 128
+
+// ClearTransparent returns a copy of img in which pixels with an alpha below
+// threshold are fully transparent and every other pixel is opaque. Fully
+// transparent pixels become empty cells that are left out of the palette,
+// the chart and its stitch counts, whatever the threshold.
+func ClearTransparent(img image.Image, threshold uint8) image.Image {
+	src := asRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				i, j := y*src.Stride+x*4, y*dst.Stride+x*4
+				if src.Pix[i+3] == 0 || src.Pix[i+3] < threshold {
+					continue
+				}
+				c := pixelAt(src.Pix, i)
+				dst.Pix[j], dst.Pix[j+1], dst.Pix[j+2], dst.Pix[j+3] = c.R, c.G, c.B, 255
+			}
+		}
+	})
+	return dst
+}
+
+// parallelRange splits [0, n) into one band per CPU and calls fn on each
+// band from its own goroutine, returning once all have finished.
+func parallelRange(n int, fn func(lo, hi int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		if n > 0 {
+			fn(0, n)
+		}
+		return
+	}
+
+	band := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += band {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			fn(lo, hi)
+		}(lo, min(lo+band, n))
+	}
+	wg.Wait()
+}
+
+// paletteIndices matches every pixel of img to its nearest palette thread
+// and returns the palette index of each pixel, row by row, or -1 for a
+// transparent pixel. Each distinct color is matched once, with the matching
+// spread across goroutines.
+func paletteIndices(img *image.RGBA, palette []common.ThreadColor, metric colormath.Metric) []int {
+	hist := colorHistogram(img)
+	colors := make([]color.RGBA, 0, len(hist))
+	for c := range hist {
+		colors = append(colors, c)
+	}
+
+	matcher := colormath.NewIndexMatcher(palette, metric)
+	matched := make([]int, len(colors))
+	parallelRange(len(colors), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			matched[i] = matcher.NearestIndex(colors[i])
+		}
+	})
+	lookup := make(map[color.RGBA]int, len(colors))
+	for i, c := range colors {
+		lookup[c] = matched[i]
+	}
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	indices := make([]int, width*height)
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := y * img.Stride
+			for x := 0; x < width; x++ {
+				if transparentAt(img.Pix, row+x*4) {
+					indices[y*width+x] = -1
+					continue
+				}
+				indices[y*width+x] = lookup[pixelAt(img.Pix, row+x*4)]
+			}
+		}
+	})
+	return indices
+}
+
+// IndexedImage is an image reduced to a thread palette. It keeps the
+// palette index of every pixel so later stages need not match colors
+// again. Its pixels must not be changed.
+type IndexedImage struct {
+	*image.RGBA
+	Palette []common.ThreadColor
+	// Indices holds the palette index of each pixel, row by row, or -1 for
+	// an empty cell.
+	Indices []int
+}
+
+// newIndexedImage draws the image of a palette index map, leaving pixels
+// without a thread transparent.
+func newIndexedImage(width, height int, palette []common.ThreadColor, indices []int) *IndexedImage {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := y * img.Stride
+			for x := 0; x < width; x++ {
+				k := indices[y*width+x]
+				if k < 0 {
+					continue
+				}
+				c := palette[k].Color
+				i := row + x*4
+				img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, 255
+			}
+		}
+	})
+	return &IndexedImage{RGBA: img, Palette: palette, Indices: indices}
+}
+
+// indexedFor returns the palette indices of img, reusing those of an
+// IndexedImage of the same palette.
+func indexedFor(img image.Image, palette []common.ThreadColor, metric colormath.Metric) []int {
+	if indexed, ok := img.(*IndexedImage); ok && samePalette(indexed.Palette, palette) {
+		return indexed.Indices
+	}
+	return paletteIndices(asRGBA(img), palette, metric)
+}
+
+func samePalette(a, b []common.ThreadColor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,310 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rotation turns an image clockwise by a multiple of 90 degrees.
+type Rotation int
+
+const (
+	Rotate0 Rotation = iota
+	Rotate90
+	Rotate180
+	Rotate270
+)
+
+// Rotations lists every rotation, in display order.
+var Rotations = []Rotation{Rotate0, Rotate90, Rotate180, Rotate270}
+
+func (r Rotation) String() string {
+	switch r {
+	case Rotate0, Rotate90, Rotate180, Rotate270:
+		return strconv.Itoa(int(r)*90) + "°"
+	}
+	return fmt.Sprintf("Rotation(%d)", int(r))
+}
+
+// ParseRotation returns the rotation of s degrees clockwise, with or without
+// a degree sign.
+func ParseRotation(s string) (Rotation, error) {
+	for _, r := range Rotations {
+		if strings.TrimSuffix(r.String(), "°") == strings.TrimSuffix(strings.TrimSpace(s), "°") {
+			return r, nil
+		}
+	}
+	return Rotate0, fmt.Errorf("unknown rotation: %s (use 0, 90, 180 or 270)", s)
+}
+
+// ParseCrop parses a crop rectangle given as "x,y,width,height" in pixels
+// from the image's top left corner.
+func ParseCrop(s string) (image.Rectangle, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return image.Rectangle{}, fmt.Errorf("crop %q: want x,y,width,height", s)
+	}
+	var v [4]int
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("crop %q: %w", s, err)
+		}
+		v[i] = n
+	}
+	if v[0] < 0 || v[1] < 0 || v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("crop %q: the corner must not be negative and the size must be positive", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// PreprocessOptions are the corrections made to an image before its colors
+// are reduced. The zero value leaves the image unchanged.
+type PreprocessOptions struct {
+	// Crop keeps only this rectangle, in pixels from the image's top left
+	// corner. An empty rectangle keeps the whole image.
+	Crop     image.Rectangle
+	Rotation Rotation
+	// FlipHorizontal mirrors the image left to right and FlipVertical top
+	// to bottom, after rotating it.
+	FlipHorizontal bool
+	FlipVertical   bool
+
+	// Brightness, Contrast and Saturation range from -1 to 1, with 0
+	// leaving the image unchanged. Saturation -1 makes the image gray.
+	Brightness float64
+	Contrast   float64
+	Saturation float64
+	// Gamma brightens the mid tones above 1 and darkens them below. Zero
+	// is the same as 1.
+	Gamma float64
+	// SharpenAmount is the strength of an unsharp mask, where 1 adds the
+	// full difference from the blurred image, and SharpenRadius is the
+	// standard deviation of the blur in pixels. An amount of 0 does not
+	// sharpen.
+	SharpenAmount float64
+	SharpenRadius float64
+	// Posterize limits each channel to this many levels; below 2 it is
+	// off.
+	Posterize int
+}
+
+// DefaultSharpenRadius is the unsharp mask radius, in pixels of the chart,
+// that suits most images.
+const DefaultSharpenRadius = 1.0
+
+// Orient crops, rotates and flips img according to opts. It runs on the
+// source image, before background removal and ResizeImage, so the crop is
+// in the source's pixels.
+func Orient(img image.Image, opts PreprocessOptions) (image.Image, error) {
+	src := asRGBA(img)
+	rect := src.Rect
+	if !opts.Crop.Empty() {
+		rect = opts.Crop.Intersect(src.Rect)
+		if rect.Empty() {
+			return nil, fmt.Errorf("crop %dx%d at %d,%d is outside the %dx%d image", opts.Crop.Dx(), opts.Crop.Dy(), opts.Crop.Min.X, opts.Crop.Min.Y, src.Rect.Dx(), src.Rect.Dy())
+		}
+	}
+	if rect == src.Rect && opts.Rotation == Rotate0 && !opts.FlipHorizontal && !opts.FlipVertical {
+		return img, nil
+	}
+
+	width, height := rect.Dx(), rect.Dy()
+	outWidth, outHeight := width, height
+	if opts.Rotation == Rotate90 || opts.Rotation == Rotate270 {
+		outWidth, outHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				dx, dy := x, y
+				switch opts.Rotation {
+				case Rotate90:
+					dx, dy = height-1-y, x
+				case Rotate180:
+					dx, dy = width-1-x, height-1-y
+				case Rotate270:
+					dx, dy = y, width-1-x
+				}
+				if opts.FlipHorizontal {
+					dx = outWidth - 1 - dx
+				}
+				if opts.FlipVertical {
+					dy = outHeight - 1 - dy
+				}
+				i := (rect.Min.Y+y)*src.Stride + (rect.Min.X+x)*4
+				j := dy*dst.Stride + dx*4
+				copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+			}
+		}
+	})
+	return dst, nil
+}
+
+// OrientedSize returns the width and height Orient gives an image with the
+// given bounds, or the uncropped size when the crop misses the image.
+func OrientedSize(bounds image.Rectangle, opts PreprocessOptions) (width, height int) {
+	rect := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	if cropped := opts.Crop.Intersect(rect); !cropped.Empty() {
+		rect = cropped
+	}
+	if opts.Rotation == Rotate90 || opts.Rotation == Rotate270 {
+		return rect.Dy(), rect.Dx()
+	}
+	return rect.Dx(), rect.Dy()
+}
+
+// Adjust applies the tone, saturation, sharpening and posterizing of opts to
+// img, in that order. It runs on the image after ResizeImage, so sharpening
+// acts on stitches rather than on source pixels that resizing averages
+// away. Transparency is kept.
+func Adjust(img image.Image, opts PreprocessOptions) image.Image {
+	tone := toneCurve(opts)
+	if tone == nil && opts.Saturation == 0 && opts.SharpenAmount <= 0 && opts.Posterize < 2 {
+		return img
+	}
+
+	src := asRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Work on straight alpha colors as floats so the steps do not round
+	// twice.
+	pix := make([][3]float64, width*height)
+	opaque := make([]bool, width*height)
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				i := y*src.Stride + x*4
+				dst.Pix[y*dst.Stride+x*4+3] = src.Pix[i+3]
+				if transparentAt(src.Pix, i) {
+					continue
+				}
+				opaque[y*width+x] = true
+				c := pixelAt(src.Pix, i)
+				p := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+				if tone != nil {
+					p = [3]float64{tone[c.R], tone[c.G], tone[c.B]}
+				}
+				if opts.Saturation != 0 {
+					gray := 0.299*p[0] + 0.587*p[1] + 0.114*p[2]
+					for k := range p {
+						p[k] = gray + (p[k]-gray)*(1+math.Max(-1, opts.Saturation))
+					}
+				}
+				pix[y*width+x] = p
+			}
+		}
+	})
+
+	if opts.SharpenAmount > 0 {
+		radius := opts.SharpenRadius
+		if radius <= 0 {
+			radius = DefaultSharpenRadius
+		}
+		blurred := gaussianBlur(pix, opaque, width, height, radius)
+		for i := range pix {
+			for k := range pix[i] {
+				pix[i][k] += (pix[i][k] - blurred[i][k]) * opts.SharpenAmount
+			}
+		}
+	}
+
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				j := y*dst.Stride + x*4
+				a := dst.Pix[j+3]
+				if a == 0 {
+					continue
+				}
+				p := pix[y*width+x]
+				for k := range p {
+					v := clampChannel(p[k])
+					if opts.Posterize >= 2 {
+						step := 255 / float64(opts.Posterize-1)
+						v = clampChannel(math.Round(float64(v)/step) * step)
+					}
+					dst.Pix[j+k] = uint8(uint32(v) * uint32(a) / 255)
+				}
+			}
+		}
+	})
+	return dst
+}
+
+// toneCurve returns the brightness, contrast and gamma correction of each
+// channel value, or nil when opts leaves tones unchanged.
+func toneCurve(opts PreprocessOptions) *[256]float64 {
+	gamma := opts.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	if opts.Brightness == 0 && opts.Contrast == 0 && gamma == 1 {
+		return nil
+	}
+
+	brightness := math.Max(-1, math.Min(1, opts.Brightness)) * 255
+	contrast := 1 + math.Max(-1, math.Min(1, opts.Contrast))
+	var curve [256]float64
+	for v := range curve {
+		c := math.Max(0, math.Min(255, (float64(v)-127.5)*contrast+127.5+brightness))
+		curve[v] = 255 * math.Pow(c/255, 1/gamma)
+	}
+	return &curve
+}
+
+// gaussianBlur blurs pix, a width by height image, with a Gaussian of the
+// given standard deviation, one axis at a time. Only pixels marked opaque
+// are sampled, so transparent areas do not darken the subject's edges, and
+// samples past the image's edges repeat the edge pixels.
+func gaussianBlur(pix [][3]float64, opaque []bool, width, height int, sigma float64) [][3]float64 {
+	reach := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*reach+1)
+	for i := range kernel {
+		d := float64(i - reach)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+	}
+
+	clampIndex := func(v, n int) int {
+		return max(0, min(n-1, v))
+	}
+	// Each pass sums weighted colors with the total weight last.
+	across := make([][4]float64, len(pix))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				var sum [4]float64
+				for i, w := range kernel {
+					j := y*width + clampIndex(x+i-reach, width)
+					if !opaque[j] {
+						continue
+					}
+					p := pix[j]
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+p[0]*w, sum[1]+p[1]*w, sum[2]+p[2]*w, sum[3]+w
+				}
+				across[y*width+x] = sum
+			}
+		}
+	})
+	blurred := make([][3]float64, len(pix))
+	parallelRange(height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				var sum [4]float64
+				for i, w := range kernel {
+					p := across[clampIndex(y+i-reach, height)*width+x]
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+p[0]*w, sum[1]+p[1]*w, sum[2]+p[2]*w, sum[3]+p[3]*w
+				}
+				if sum[3] > 0 {
+					blurred[y*width+x] = [3]float64{sum[0] / sum[3], sum[1] / sum[3], sum[2] / sum[3]}
+				}
+			}
+		}
+	})
+	return blurred
+}
@@ -0,0 +1,409 @@
+package imageprocessing
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ericpauley/go-quantize/quantize"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// QuantizeMethod selects how GetPartialPalette picks representative colors.
+type QuantizeMethod int
+
+const (
+	// QuantizePopularity keeps the most frequent nearest threads.
+	QuantizePopularity QuantizeMethod = iota
+	// QuantizeMedianCut splits the color cube at the median of its widest axis.
+	QuantizeMedianCut
+	// QuantizeKMeans clusters colors with k-means in CIE L*a*b*.
+	QuantizeKMeans
+	// QuantizeOctree merges the leaves of an RGB octree.
+	QuantizeOctree
+)
+
+// QuantizeMethods lists every quantization method, in display order.
+var QuantizeMethods = []QuantizeMethod{QuantizePopularity, QuantizeMedianCut, QuantizeKMeans, QuantizeOctree}
+
+func (q QuantizeMethod) String() string {
+	switch q {
+	case QuantizePopularity:
+		return "Popularity"
+	case QuantizeMedianCut:
+		return "Median cut"
+	case QuantizeKMeans:
+		return "K-means"
+	case QuantizeOctree:
+		return "Octree"
+	}
+	return fmt.Sprintf("QuantizeMethod(%d)", int(q))
+}
+
+// ParseQuantizeMethod returns the method whose name matches s, ignoring case
+// and spaces.
+func ParseQuantizeMethod(s string) (QuantizeMethod, error) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(s))
+	}
+	for _, q := range QuantizeMethods {
+		if normalize(q.String()) == normalize(s) {
+			return q, nil
+		}
+	}
+	return QuantizePopularity, fmt.Errorf("unknown quantization method: %s", s)
+}
+
+// weightedColor is a cluster center and the number of pixels it stands for.
+type weightedColor struct {
+	Color  color.RGBA
+	Weight int
+}
+
+// colorHistogram counts the RGB colors in an image, leaving out
+// transparent pixels.
+func colorHistogram(img image.Image) map[color.RGBA]int {
+	rgba := asRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+
+	var mu sync.Mutex
+	var counts map[color.RGBA]int
+	parallelRange(height, func(y0, y1 int) {
+		band := make(map[color.RGBA]int)
+		for y := y0; y < y1; y++ {
+			row := y * rgba.Stride
+			for x := 0; x < width; x++ {
+				if !transparentAt(rgba.Pix, row+x*4) {
+					band[pixelAt(rgba.Pix, row+x*4)]++
+				}
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if counts == nil {
+			counts = band
+			return
+		}
+		for c, n := range band {
+			counts[c] += n
+		}
+	})
+	if counts == nil {
+		counts = make(map[color.RGBA]int)
+	}
+	return counts
+}
+
+// medianCutColors quantizes with go-quantize's median cut and weights each
+// center by the pixels nearest to it.
+func medianCutColors(img image.Image, k int) []weightedColor {
+	rgba := asRGBA(img)
+	q := quantize.MedianCutQuantizer{
+		Aggregation: quantize.Mean,
+		// Transparent pixels are not stitched, so they carry no weight.
+		Weighting: func(_ image.Image, x, y int) uint32 {
+			if transparentAt(rgba.Pix, rgba.PixOffset(x, y)) {
+				return 0
+			}
+			return 1
+		},
+	}
+	palette := q.Quantize(make(color.Palette, 0, k), rgba)
+	if len(palette) == 0 {
+		return nil
+	}
+
+	centers := make([]weightedColor, len(palette))
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		centers[i].Color = color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+	}
+	for c, n := range colorHistogram(img) {
+		centers[palette.Index(c)].Weight += n
+	}
+	return centers
+}
+
+// kMeansColors clusters the image's colors in Lab space. Centers are
+// seeded with k-means++ for a better start; results are the same between
+// runs because the colors are sorted and the random source has a fixed seed.
+func kMeansColors(img image.Image, k int) []weightedColor {
+	const maxIterations = 20
+
+	histogram := colorHistogram(img)
+	points := make([]colormath.Lab, 0, len(histogram))
+	weights := make([]float64, 0, len(histogram))
+	for c, n := range histogram {
+		points = append(points, colormath.RGBToLab(c.R, c.G, c.B))
+		weights = append(weights, float64(n))
+	}
+	// Map iteration order is random; sort so seeding is reproducible.
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := points[order[i]], points[order[j]]
+		if a.L != b.L {
+			return a.L < b.L
+		}
+		if a.A != b.A {
+			return a.A < b.A
+		}
+		return a.B < b.B
+	})
+	sortedPoints := make([]colormath.Lab, len(points))
+	sortedWeights := make([]float64, len(points))
+	for i, o := range order {
+		sortedPoints[i], sortedWeights[i] = points[o], weights[o]
+	}
+	points, weights = sortedPoints, sortedWeights
+
+	if k > len(points) {
+		k = len(points)
+	}
+	if k == 0 {
+		return nil
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	centers := make([]colormath.Lab, 0, k)
+	nearest := make([]float64, len(points))
+	for i := range nearest {
+		nearest[i] = math.MaxFloat64
+	}
+	centers = append(centers, points[rng.Intn(len(points))])
+	for len(centers) < k {
+		total := 0.0
+		last := centers[len(centers)-1]
+		for i, p := range points {
+			d := colormath.CIE76(p, last)
+			if d*d < nearest[i] {
+				nearest[i] = d * d
+			}
+			total += nearest[i] * weights[i]
+		}
+		target := rng.Float64() * total
+		next := len(points) - 1
+		for i := range points {
+			target -= nearest[i] * weights[i]
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, points[next])
+	}
+
+	assignment := make([]int, len(points))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := false
+		for i, p := range points {
+			best, bestDistance := 0, math.MaxFloat64
+			for j, c := range centers {
+				if d := colormath.CIE76(p, c); d < bestDistance {
+					best, bestDistance = j, d
+				}
+			}
+			if assignment[i] != best || iteration == 0 {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([]colormath.Lab, k)
+		totals := make([]float64, k)
+		for i, p := range points {
+			j := assignment[i]
+			sums[j].L += p.L * weights[i]
+			sums[j].A += p.A * weights[i]
+			sums[j].B += p.B * weights[i]
+			totals[j] += weights[i]
+		}
+		for j := range centers {
+			if totals[j] > 0 {
+				centers[j] = colormath.Lab{L: sums[j].L / totals[j], A: sums[j].A / totals[j], B: sums[j].B / totals[j]}
+			}
+		}
+	}
+
+	result := make([]weightedColor, k)
+	for j, c := range centers {
+		result[j].Color = colormath.LabToRGB(c)
+	}
+	for i := range points {
+		result[assignment[i]].Weight += int(weights[i])
+	}
+	return result
+}
+
+type octreeNode struct {
+	r, g, b  int
+	count    int
+	children [8]*octreeNode
+	leaf     bool
+}
+
+// octreeColors builds an RGB octree of the image's colors and merges the
+// least populated deepest nodes until at most k leaves remain.
+func octreeColors(img image.Image, k int) []weightedColor {
+	const maxDepth = 8
+
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, maxDepth)
+	leaves := 0
+
+	for c, n := range colorHistogram(img) {
+		node := root
+		for depth := 0; depth < maxDepth; depth++ {
+			shift := uint(7 - depth)
+			i := int(c.R>>shift&1)<<2 | int(c.G>>shift&1)<<1 | int(c.B>>shift&1)
+			if node.children[i] == nil {
+				node.children[i] = &octreeNode{}
+				if depth < maxDepth-1 {
+					levels[depth] = append(levels[depth], node.children[i])
+				} else {
+					node.children[i].leaf = true
+					leaves++
+				}
+			}
+			node = node.children[i]
+		}
+		node.r += int(c.R) * n
+		node.g += int(c.G) * n
+		node.b += int(c.B) * n
+		node.count += n
+	}
+
+	// Fold children into their parent, deepest level first and least
+	// populated nodes first, until the leaf count fits.
+	for depth := maxDepth - 2; depth >= 0 && leaves > k; depth-- {
+		nodes := levels[depth]
+		for _, node := range nodes {
+			for _, child := range node.children {
+				if child != nil {
+					node.count += subtreeCount(child)
+				}
+			}
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		for _, node := range nodes {
+			if leaves <= k {
+				break
+			}
+			merged := 0
+			for i, child := range node.children {
+				if child == nil {
+					continue
+				}
+				node.r += child.r
+				node.g += child.g
+				node.b += child.b
+				node.children[i] = nil
+				merged++
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+		for _, node := range nodes {
+			if !node.leaf {
+				node.count = 0
+			}
+		}
+	}
+
+	var result []weightedColor
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			if node.count > 0 {
+				result = append(result, weightedColor{
+					Color: color.RGBA{
+						R: uint8(node.r / node.count),
+						G: uint8(node.g / node.count),
+						B: uint8(node.b / node.count),
+						A: 255,
+					},
+					Weight: node.count,
+				})
+			}
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+	return result
+}
+
+// subtreeCount totals the pixels held by the leaves under node.
+func subtreeCount(node *octreeNode) int {
+	if node.leaf {
+		return node.count
+	}
+	total := 0
+	for _, child := range node.children {
+		if child != nil {
+			total += subtreeCount(child)
+		}
+	}
+	return total
+}
+
+// snapToThreads replaces each center with a distinct thread, visiting the
+// heaviest centers first so they get their closest match.
+func snapToThreads(centers []weightedColor, threadColors []common.ThreadColor, metric colormath.Metric) []common.ThreadColor {
+	sort.SliceStable(centers, func(i, j int) bool { return centers[i].Weight > centers[j].Weight })
+
+	threadLab := make([]colormath.Lab, len(threadColors))
+	for i, tc := range threadColors {
+		threadLab[i] = colormath.RGBToLab(tc.Color.R, tc.Color.G, tc.Color.B)
+	}
+
+	used := make(map[int]bool)
+	var selected []common.ThreadColor
+	for _, center := range centers {
+		if center.Weight == 0 {
+			continue
+		}
+		lab := colormath.RGBToLab(center.Color.R, center.Color.G, center.Color.B)
+		best, bestDistance := -1, math.MaxFloat64
+		for i, tc := range threadColors {
+			if used[i] {
+				continue
+			}
+			var distance float64
+			if metric.UsesLab() {
+				distance = metric.LabDistance(lab, threadLab[i])
+			} else {
+				dr := float64(center.Color.R) - float64(tc.Color.R)
+				dg := float64(center.Color.G) - float64(tc.Color.G)
+				db := float64(center.Color.B) - float64(tc.Color.B)
+				distance = math.Sqrt(dr*dr + dg*dg + db*db)
+			}
+			if distance < bestDistance {
+				best, bestDistance = i, distance
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		selected = append(selected, threadColors[best])
+	}
+	return selected
+}
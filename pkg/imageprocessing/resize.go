@@ -5,8 +5,10 @@ import (
 	"image"
 	"image/jpeg"
 	"image/png"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/image/draw"
 )
@@ -49,15 +51,211 @@ func SaveImage(filePath string, img image.Image) error {
 	}
 }
 
-// ResizeImage resizes the image to the specified height while maintaining aspect ratio.
-func ResizeImage(img image.Image, newHeight int) image.Image {
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+// Resampler selects how ResizeImage interpolates between source pixels.
+type Resampler int
 
-	newWidth := (newHeight * width) / height
+const (
+	ResampleCatmullRom Resampler = iota
+	// ResampleNearest copies the nearest source pixel, keeping hard edges
+	// and adding no colors.
+	ResampleNearest
+	// ResampleBox averages the source pixels each stitch covers.
+	ResampleBox
+	ResampleBilinear
+	// ResampleLanczos uses a three lobe Lanczos filter, the sharpest of the
+	// smooth resamplers.
+	ResampleLanczos
+)
+
+// Resamplers lists every resampler, in display order.
+var Resamplers = []Resampler{ResampleNearest, ResampleBox, ResampleBilinear, ResampleCatmullRom, ResampleLanczos}
+
+// DefaultResampler is the resampler used unless another is chosen, the
+// zero Resampler.
+const DefaultResampler = ResampleCatmullRom
+
+func (r Resampler) String() string {
+	switch r {
+	case ResampleNearest:
+		return "Nearest"
+	case ResampleBox:
+		return "Box"
+	case ResampleBilinear:
+		return "Bilinear"
+	case ResampleCatmullRom:
+		return "CatmullRom"
+	case ResampleLanczos:
+		return "Lanczos"
+	}
+	return fmt.Sprintf("Resampler(%d)", int(r))
+}
+
+// ParseResampler returns the resampler whose name matches s, ignoring case.
+func ParseResampler(s string) (Resampler, error) {
+	for _, r := range Resamplers {
+		if strings.EqualFold(r.String(), s) {
+			return r, nil
+		}
+	}
+	return DefaultResampler, fmt.Errorf("unknown resampler: %s", s)
+}
+
+// boxKernel averages every source pixel under a destination pixel when
+// shrinking, since draw widens kernels by the scale factor.
+var boxKernel = &draw.Kernel{Support: 0.5, At: func(t float64) float64 {
+	return 1
+}}
+
+// lanczosKernel is the Lanczos filter with a = 3.
+var lanczosKernel = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+func (r Resampler) scaler() draw.Scaler {
+	switch r {
+	case ResampleNearest:
+		return draw.NearestNeighbor
+	case ResampleBox:
+		return boxKernel
+	case ResampleBilinear:
+		return draw.BiLinear
+	case ResampleLanczos:
+		return lanczosKernel
+	}
+	return draw.CatmullRom
+}
+
+// FitMode says how ResizeImage fits an image into a chart whose width and
+// height are both given.
+type FitMode int
+
+const (
+	// FitInside keeps the image's aspect ratio and makes the chart as large
+	// as fits within the width and height.
+	FitInside FitMode = iota
+	// FitFill keeps the aspect ratio and fills the whole width and height,
+	// cropping the image's edges evenly.
+	FitFill
+	// FitStretch fills the width and height, distorting the image.
+	FitStretch
+)
+
+// FitModes lists every fit mode, in display order.
+var FitModes = []FitMode{FitInside, FitFill, FitStretch}
+
+func (f FitMode) String() string {
+	switch f {
+	case FitInside:
+		return "Fit"
+	case FitFill:
+		return "Fill"
+	case FitStretch:
+		return "Stretch"
+	}
+	return fmt.Sprintf("FitMode(%d)", int(f))
+}
+
+// ParseFitMode returns the fit mode whose name matches s, ignoring case.
+func ParseFitMode(s string) (FitMode, error) {
+	for _, f := range FitModes {
+		if strings.EqualFold(f.String(), s) {
+			return f, nil
+		}
+	}
+	return FitInside, fmt.Errorf("unknown fit mode: %s", s)
+}
+
+// ResizeOptions size a chart in stitches.
+type ResizeOptions struct {
+	// Width and Height are the chart size in stitches. When only one is
+	// given the other follows the image's aspect ratio; when both are, Fit
+	// decides how the image fills them.
+	Width, Height int
+	Fit           FitMode
+	// MaxStitches limits the chart to this many stitches in total, keeping
+	// its aspect ratio. Without a Width or Height it sets the size alone.
+	// Zero is no limit.
+	MaxStitches int
+	// StitchAspect is the width of a stitch over its height on the fabric,
+	// so that images keep their proportions on fabric with more threads
+	// one way than the other. Zero is the same as 1, square stitches.
+	StitchAspect float64
+	Resampler    Resampler
+}
+
+// ChartSize returns the width and height in stitches ResizeImage gives an
+// image with the given bounds. With no size or limit in opts, the chart
+// has one row of stitches per image row.
+func ChartSize(bounds image.Rectangle, opts ResizeOptions) (width, height int) {
+	// aspect is the image's width over its height, in stitches.
+	aspect := float64(bounds.Dx()) / float64(bounds.Dy())
+	if opts.StitchAspect > 0 {
+		aspect /= opts.StitchAspect
+	}
+	across := func(height int) int {
+		return max(1, int(float64(height)*aspect+1e-9))
+	}
+	down := func(width int) int {
+		return max(1, int(float64(width)/aspect+1e-9))
+	}
+
+	switch {
+	case opts.Width > 0 && opts.Height > 0:
+		width, height = opts.Width, opts.Height
+		if opts.Fit == FitInside {
+			if float64(opts.Width)/float64(opts.Height) > aspect {
+				width = across(opts.Height)
+			} else {
+				height = down(opts.Width)
+			}
+		}
+	case opts.Width > 0:
+		width, height = opts.Width, down(opts.Width)
+	case opts.Height > 0:
+		width, height = across(opts.Height), opts.Height
+	case opts.MaxStitches > 0:
+		height = max(1, int(math.Sqrt(float64(opts.MaxStitches)/aspect)))
+		width = across(height)
+	default:
+		width, height = across(bounds.Dy()), bounds.Dy()
+	}
+
+	if opts.MaxStitches > 0 && width*height > opts.MaxStitches {
+		shrink := math.Sqrt(float64(opts.MaxStitches) / float64(width*height))
+		width = max(1, int(float64(width)*shrink))
+		height = max(1, int(float64(height)*shrink))
+	}
+	return width, height
+}
+
+// ResizeImage scales the image to the chart size opts describes with
+// opts.Resampler, cropping its edges when opts.Fit is FitFill.
+func ResizeImage(img image.Image, opts ResizeOptions) image.Image {
+	width, height := ChartSize(img.Bounds(), opts)
+	source := img.Bounds()
+	if opts.Fit == FitFill && opts.Width > 0 && opts.Height > 0 {
+		// Crop to the chart's proportions in image pixels.
+		stitchAspect := opts.StitchAspect
+		if stitchAspect <= 0 {
+			stitchAspect = 1
+		}
+		want := float64(width) * stitchAspect / float64(height)
+		if float64(source.Dx())/float64(source.Dy()) > want {
+			cropWidth := max(1, int(math.Round(float64(source.Dy())*want)))
+			source.Min.X += (source.Dx() - cropWidth) / 2
+			source.Max.X = source.Min.X + cropWidth
+		} else {
+			cropHeight := max(1, int(math.Round(float64(source.Dx())/want)))
+			source.Min.Y += (source.Dy() - cropHeight) / 2
+			source.Max.Y = source.Min.Y + cropHeight
+		}
+	}
 
-	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	opts.Resampler.scaler().Scale(dst, dst.Bounds(), img, source, draw.Over, nil)
 	return dst
 }
@@ -0,0 +1,201 @@
+package materials
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fabric is a cross stitch fabric. Evenweave and linen are usually worked
+// over two threads, so a 28-count evenweave holds 14 stitches per inch.
+type Fabric struct {
+	Name string
+	// Count is the fabric's threads per inch across.
+	Count int
+	// CountDown is the threads per inch down on fabric woven unevenly, as
+	// some linens are, so that its stitches are not square. Zero means the
+	// same as Count.
+	CountDown int
+	// Over is how many fabric threads each stitch covers.
+	Over int
+}
+
+// Fabrics lists the common fabrics, finest last.
+var Fabrics = []Fabric{
+	{Name: "11-count Aida", Count: 11, Over: 1},
+	{Name: "14-count Aida", Count: 14, Over: 1},
+	{Name: "16-count Aida", Count: 16, Over: 1},
+	{Name: "18-count Aida", Count: 18, Over: 1},
+	{Name: "22-count Hardanger", Count: 22, Over: 1},
+	{Name: "28-count evenweave over 2", Count: 28, Over: 2},
+}
+
+// DefaultFabric is 14-count Aida, the most common fabric.
+var DefaultFabric = Fabrics[1]
+
+func (f Fabric) String() string {
+	return f.Name
+}
+
+// StitchesPerInch is how many stitches fit in an inch of the fabric,
+// across.
+func (f Fabric) StitchesPerInch() float64 {
+	if f.Over <= 1 {
+		return float64(f.Count)
+	}
+	return float64(f.Count) / float64(f.Over)
+}
+
+// StitchesPerInchDown is how many stitches fit in an inch of the fabric,
+// down.
+func (f Fabric) StitchesPerInchDown() float64 {
+	if f.CountDown <= 0 {
+		return f.StitchesPerInch()
+	}
+	return Fabric{Count: f.CountDown, Over: f.Over}.StitchesPerInch()
+}
+
+// StitchAspect is the width of a stitch on the fabric over its height, 1
+// for evenly woven fabric.
+func (f Fabric) StitchAspect() float64 {
+	return f.StitchesPerInchDown() / f.StitchesPerInch()
+}
+
+// ParseFabric returns the fabric with the given name, or one described by
+// its count such as "14" or "28-over-2". Unevenly woven fabric gives its
+// count across and down, such as "28x26-over-2".
+func ParseFabric(name string) (Fabric, error) {
+	for _, f := range Fabrics {
+		if strings.EqualFold(f.Name, name) {
+			return f, nil
+		}
+	}
+
+	count, over := name, "1"
+	if i := strings.Index(strings.ToLower(name), "over"); i >= 0 {
+		count, over = name[:i], name[i+len("over"):]
+	}
+	count, countDown, uneven := strings.Cut(strings.ReplaceAll(strings.ToLower(count), "×", "x"), "x")
+	c, err1 := strconv.Atoi(strings.Trim(count, " -/"))
+	o, err2 := strconv.Atoi(strings.Trim(over, " -/"))
+	d := 0
+	var err3 error
+	if uneven {
+		d, err3 = strconv.Atoi(strings.Trim(countDown, " -/"))
+	}
+	if err1 != nil || err2 != nil || err3 != nil || c <= 0 || o <= 0 || uneven && d == 0 {
+		return DefaultFabric, fmt.Errorf("unknown fabric: %s", name)
+	}
+	if d == c {
+		d = 0
+	}
+	for _, f := range Fabrics {
+		if f.Count == c && f.CountDown == d && f.Over == o {
+			return f, nil
+		}
+	}
+
+	counts := strconv.Itoa(c)
+	if d > 0 {
+		counts += fmt.Sprintf("×%d", d)
+	}
+	if o == 1 {
+		return Fabric{Name: counts + "-count", Count: c, CountDown: d, Over: 1}, nil
+	}
+	return Fabric{Name: fmt.Sprintf("%s-count over %d", counts, o), Count: c, CountDown: d, Over: o}, nil
+}
+
+// Unit is a unit of physical length.
+type Unit int
+
+const (
+	Inches Unit = iota
+	Centimeters
+)
+
+// Units lists every unit, in the order they are offered.
+var Units = []Unit{Inches, Centimeters}
+
+func (u Unit) String() string {
+	switch u {
+	case Inches:
+		return "in"
+	case Centimeters:
+		return "cm"
+	}
+	return fmt.Sprintf("Unit(%d)", int(u))
+}
+
+// ParseUnit returns the unit with the given name or abbreviation.
+func ParseUnit(name string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "in", "inch", "inches", "\"":
+		return Inches, nil
+	case "cm", "centimeter", "centimeters", "centimetre", "centimetres":
+		return Centimeters, nil
+	}
+	return Inches, fmt.Errorf("unknown unit: %s", name)
+}
+
+// ToInches converts a length in this unit to inches.
+func (u Unit) ToInches(v float64) float64 {
+	if u == Centimeters {
+		return v / 2.54
+	}
+	return v
+}
+
+// FromInches converts a length in inches to this unit.
+func (u Unit) FromInches(v float64) float64 {
+	if u == Centimeters {
+		return v * 2.54
+	}
+	return v
+}
+
+// DefaultCutMargin is the fabric left around the design on every side, in
+// inches, for framing or finishing.
+const DefaultCutMargin = 3.0
+
+// StitchesAcross returns how many stitches span a width of fabric, at least
+// one.
+func StitchesAcross(length float64, unit Unit, f Fabric) int {
+	return stitchesIn(unit.ToInches(length), f.StitchesPerInch())
+}
+
+// StitchesDown returns how many stitches span a height of fabric, at least
+// one.
+func StitchesDown(length float64, unit Unit, f Fabric) int {
+	return stitchesIn(unit.ToInches(length), f.StitchesPerInchDown())
+}
+
+func stitchesIn(inches, perInch float64) int {
+	n := int(math.Round(inches * perInch))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// FinishedSize returns the stitched width and height of a design in inches.
+func FinishedSize(width, height int, f Fabric) (float64, float64) {
+	return float64(width) / f.StitchesPerInch(), float64(height) / f.StitchesPerInchDown()
+}
+
+// CutSize returns the piece of fabric to cut for a design in inches: the
+// finished size plus margin inches on every side.
+func CutSize(width, height int, f Fabric, margin float64) (float64, float64) {
+	w, h := FinishedSize(width, height, f)
+	return w + 2*margin, h + 2*margin
+}
+
+// SizeSummary describes a design's finished and cut sizes on a fabric in
+// the given unit.
+func SizeSummary(width, height int, f Fabric, unit Unit) string {
+	w, h := FinishedSize(width, height, f)
+	cw, ch := CutSize(width, height, f, DefaultCutMargin)
+	return fmt.Sprintf("%d × %d stitches on %s: finished %.1f × %.1f %s, cut fabric %.1f × %.1f %s (%.3g %s margins)",
+		width, height, f, unit.FromInches(w), unit.FromInches(h), unit,
+		unit.FromInches(cw), unit.FromInches(ch), unit, unit.FromInches(DefaultCutMargin), unit)
+}
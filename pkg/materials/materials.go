@@ -0,0 +1,243 @@
+// Package materials estimates how much thread a pattern needs and what it
+// costs.
+package materials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// Options describes how a pattern is stitched.
+type Options struct {
+	// FabricCount is stitches per inch. Defaults to 14.
+	FabricCount int
+	// Strands is how many strands of floss each stitch is worked with.
+	// Defaults to 2.
+	Strands int
+	// WasteFactor scales the thread length to allow for starting, ending
+	// and travelling between stitches. Defaults to 1.2.
+	WasteFactor float64
+	// SkeinLength is the length of one skein in metres. Defaults to 8, the
+	// length of a DMC stranded cotton skein.
+	SkeinLength float64
+	// SkeinStrands is how many strands a skein divides into. Defaults to 6.
+	SkeinStrands int
+	// Prices gives the cost of one skein of each thread. Costs are left
+	// out of the estimate when it is nil.
+	Prices *PriceTable
+}
+
+func (o Options) withDefaults() Options {
+	if o.FabricCount <= 0 {
+		o.FabricCount = 14
+	}
+	if o.Strands <= 0 {
+		o.Strands = 2
+	}
+	if o.WasteFactor <= 0 {
+		o.WasteFactor = 1.2
+	}
+	if o.SkeinLength <= 0 {
+		o.SkeinLength = 8
+	}
+	if o.SkeinStrands <= 0 {
+		o.SkeinStrands = 6
+	}
+	return o
+}
+
+// ThreadUsage is the estimated consumption of one thread.
+type ThreadUsage struct {
+	Thread   common.ThreadColor
+	Stitches int
+	// Length is the floss needed in metres, counting every strand and the
+	// waste factor.
+	Length float64
+	// Skeins is the fractional number of skeins Length uses.
+	Skeins float64
+	// SkeinsToBuy rounds Skeins up to whole skeins.
+	SkeinsToBuy int
+	// Cost is SkeinsToBuy at the thread's price. Priced is false when the
+	// price table has no price for the thread.
+	Cost   float64
+	Priced bool
+}
+
+// Estimate is the thread consumption of a whole pattern.
+type Estimate struct {
+	// Threads holds one entry per pattern palette entry, in palette order.
+	// A blend's entry counts the thread of both its strands.
+	Threads []ThreadUsage
+	// Purchases holds one entry per real thread, with blends split between
+	// their two threads, in order of first use.
+	Purchases []ThreadUsage
+	Stitches  int
+	Length    float64
+	// SkeinsToBuy and Cost total Purchases, so a thread used both on its
+	// own and in a blend is only rounded up to whole skeins once.
+	SkeinsToBuy int
+	Cost        float64
+	// Priced is true when a price table was given.
+	Priced bool
+}
+
+// Used returns the usages of threads that have at least one stitch.
+func (e Estimate) Used() []ThreadUsage {
+	var used []ThreadUsage
+	for _, u := range e.Threads {
+		if u.Stitches > 0 || u.Length > 0 {
+			used = append(used, u)
+		}
+	}
+	return used
+}
+
+// EstimatePattern estimates the thread each palette entry of the pattern
+// needs. A full cross stitch is counted as its two diagonals on the front
+// and two straight runs behind; a backstitch as its length front and back.
+// A blend's strands are split evenly between its two threads.
+func EstimatePattern(p *common.Pattern, opts Options) Estimate {
+	opts = opts.withDefaults()
+
+	// Thread lengths in units of the stitch width, per single strand.
+	units := make([]float64, len(p.Palette))
+	for i, entry := range p.Palette {
+		units[i] = float64(entry.Count) * (2*math.Sqrt2 + 2)
+	}
+	for _, b := range p.Backstitches {
+		if b.PaletteIndex >= 0 && b.PaletteIndex < len(units) {
+			units[b.PaletteIndex] += 2 * math.Hypot(b.X2-b.X1, b.Y2-b.Y1)
+		}
+	}
+
+	const metresPerInch = 0.0254
+	skeinLength := opts.SkeinLength * float64(opts.SkeinStrands)
+	e := Estimate{Priced: opts.Prices != nil}
+
+	// usage fills in the skeins and cost of a thread used for length.
+	usage := func(tc common.ThreadColor, stitches int, length float64) ThreadUsage {
+		u := ThreadUsage{Thread: tc, Stitches: stitches, Length: length}
+		u.Skeins = length / skeinLength
+		u.SkeinsToBuy = int(math.Ceil(u.Skeins - 1e-9))
+		if opts.Prices != nil {
+			if price, ok := opts.Prices.Price(tc.ID); ok {
+				u.Cost = float64(u.SkeinsToBuy) * price
+				u.Priced = true
+			}
+		}
+		return u
+	}
+
+	purchases := make(map[stashKey]int)
+	for i, entry := range p.Palette {
+		length := units[i] / float64(opts.FabricCount) * metresPerInch * float64(opts.Strands) * opts.WasteFactor
+
+		parts := entry.Thread.Parts()
+		u := ThreadUsage{Thread: entry.Thread, Stitches: entry.Count, Length: length, Skeins: length / skeinLength, Priced: true}
+		for _, part := range parts {
+			pu := usage(part, entry.Count, length/float64(len(parts)))
+			u.SkeinsToBuy += pu.SkeinsToBuy
+			u.Cost += pu.Cost
+			u.Priced = u.Priced && pu.Priced
+
+			if length == 0 {
+				continue
+			}
+			k, ok := purchases[keyOf(part)]
+			if !ok {
+				purchases[keyOf(part)] = len(e.Purchases)
+				e.Purchases = append(e.Purchases, pu)
+				continue
+			}
+			prev := e.Purchases[k]
+			e.Purchases[k] = usage(prev.Thread, prev.Stitches+pu.Stitches, prev.Length+pu.Length)
+		}
+
+		e.Threads = append(e.Threads, u)
+		e.Stitches += u.Stitches
+		e.Length += u.Length
+	}
+	for _, pu := range e.Purchases {
+		e.SkeinsToBuy += pu.SkeinsToBuy
+		e.Cost += pu.Cost
+	}
+	return e
+}
+
+// PriceTable holds the price of one skein per thread ID.
+type PriceTable struct {
+	// Default is used for threads without their own price when
+	// HasDefault is set.
+	Default    float64
+	HasDefault bool
+	ByID       map[int]float64
+}
+
+// Price returns the price of one skein of the thread with the given ID.
+func (t *PriceTable) Price(id int) (float64, bool) {
+	if price, ok := t.ByID[id]; ok {
+		return price, true
+	}
+	return t.Default, t.HasDefault
+}
+
+// ReadPrices reads a price table with one "<thread id>,<price>" pair per
+// line. The ID "default" sets the price of threads not listed. Blank lines
+// and lines starting with # are ignored, and tabs or spaces may separate
+// the fields instead of a comma.
+func ReadPrices(r io.Reader) (*PriceTable, error) {
+	table := &PriceTable{ByID: make(map[int]float64)}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == '\t' || r == ' '
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a thread ID and a price, got %q", line, text)
+		}
+		price, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || price < 0 {
+			return nil, fmt.Errorf("line %d: invalid price %q", line, fields[1])
+		}
+
+		if strings.EqualFold(fields[0], "default") {
+			table.Default = price
+			table.HasDefault = true
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid thread ID %q", line, fields[0])
+		}
+		table.ByID[id] = price
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// LoadPrices reads a price table file.
+func LoadPrices(path string) (*PriceTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadPrices(file)
+}
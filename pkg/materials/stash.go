@@ -0,0 +1,211 @@
+package materials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// stashKey identifies a thread across brands.
+type stashKey struct {
+	brand string
+	id    int
+}
+
+func keyOf(tc common.ThreadColor) stashKey {
+	return stashKey{brand: strings.ToLower(tc.BrandName()), id: tc.ID}
+}
+
+// Stash is the thread a stitcher already owns, in skeins.
+type Stash struct {
+	skeins map[stashKey]float64
+}
+
+// NewStash returns an empty stash.
+func NewStash() *Stash {
+	return &Stash{skeins: make(map[stashKey]float64)}
+}
+
+// Add records skeins of a thread, adding to any already in the stash.
+func (s *Stash) Add(tc common.ThreadColor, skeins float64) {
+	s.skeins[keyOf(tc)] += skeins
+}
+
+// Skeins returns how many skeins of the thread the stash holds.
+func (s *Stash) Skeins(tc common.ThreadColor) float64 {
+	return s.skeins[keyOf(tc)]
+}
+
+// Has reports whether the stash holds any of the thread.
+func (s *Stash) Has(tc common.ThreadColor) bool {
+	return s.Skeins(tc) > 0
+}
+
+// Len returns how many different threads the stash holds.
+func (s *Stash) Len() int {
+	n := 0
+	for _, skeins := range s.skeins {
+		if skeins > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Filter returns the threads of a library that are in the stash, for use
+// as the palette a chart is generated from.
+func (s *Stash) Filter(threadColors []common.ThreadColor) []common.ThreadColor {
+	var owned []common.ThreadColor
+	for _, tc := range threadColors {
+		if s.Has(tc) {
+			owned = append(owned, tc)
+		}
+	}
+	return owned
+}
+
+// ReadStash reads a stash with one "<thread>,<skeins>" pair per line. The
+// thread is an ID, which is taken to be DMC, or a brand and ID such as
+// "Anchor 403". Skeins may be fractional for part-used skeins. Blank lines
+// and lines starting with # are ignored, and a tab may separate the fields
+// instead of a comma.
+func ReadStash(r io.Reader) (*Stash, error) {
+	stash := NewStash()
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == '\t'
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a thread and a number of skeins, got %q", line, text)
+		}
+		skeins, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || skeins < 0 {
+			return nil, fmt.Errorf("line %d: invalid number of skeins %q", line, fields[1])
+		}
+
+		thread := strings.Fields(fields[0])
+		if len(thread) == 0 {
+			return nil, fmt.Errorf("line %d: missing thread", line)
+		}
+		id, err := strconv.Atoi(thread[len(thread)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid thread ID %q", line, thread[len(thread)-1])
+		}
+		stash.Add(common.ThreadColor{ID: id, Brand: strings.Join(thread[:len(thread)-1], " ")}, skeins)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stash, nil
+}
+
+// LoadStash reads a stash file.
+func LoadStash(path string) (*Stash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadStash(file)
+}
+
+// StashUsage compares what a chart needs of one thread with the stash.
+type StashUsage struct {
+	ThreadUsage
+	// Owned is the skeins in the stash before stitching.
+	Owned float64
+	// Remaining is what is left of the owned skeins after stitching.
+	Remaining float64
+	// ToBuy is how many whole skeins must be bought to finish the chart.
+	ToBuy int
+}
+
+// StashReport is a chart's shopping list against a stash.
+type StashReport struct {
+	Threads []StashUsage
+	// SkeinsToBuy and Cost total the skeins to buy and their price.
+	SkeinsToBuy int
+	Cost        float64
+	Priced      bool
+}
+
+// Shopping returns the threads that need buying.
+func (r StashReport) Shopping() []StashUsage {
+	var out []StashUsage
+	for _, u := range r.Threads {
+		if u.ToBuy > 0 {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// CompareStash works out how much of each owned thread a chart uses and
+// what has to be bought, counting both threads of every blend. A nil stash
+// owns nothing. Costs are priced with prices when it is not nil.
+func CompareStash(e Estimate, stash *Stash, prices *PriceTable) StashReport {
+	if stash == nil {
+		stash = NewStash()
+	}
+	report := StashReport{Priced: prices != nil}
+	for _, u := range e.Purchases {
+		su := StashUsage{ThreadUsage: u, Owned: stash.Skeins(u.Thread)}
+		su.Remaining = math.Max(0, su.Owned-u.Skeins)
+		if short := u.Skeins - su.Owned; short > 1e-9 {
+			su.ToBuy = int(math.Ceil(short - 1e-9))
+		}
+		report.SkeinsToBuy += su.ToBuy
+		if prices != nil {
+			if price, ok := prices.Price(u.Thread.ID); ok {
+				report.Cost += float64(su.ToBuy) * price
+			}
+		}
+		report.Threads = append(report.Threads, su)
+	}
+
+	sort.SliceStable(report.Threads, func(i, j int) bool {
+		return report.Threads[i].ToBuy > report.Threads[j].ToBuy
+	})
+	return report
+}
+
+// WriteText writes the report as a table of every thread the chart uses,
+// threads to buy first, followed by a summary.
+func (r StashReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Thread\tName\tNeeded\tOwned\tLeft after\tBuy\t\n")
+	for _, u := range r.Threads {
+		buy := "-"
+		if u.ToBuy > 0 {
+			buy = strconv.Itoa(u.ToBuy)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%.2f\t%s\t\n", u.Thread.Code(), u.Thread.Name, u.Skeins, u.Owned, u.Remaining, buy)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("%d of %d threads to buy, %d skeins", len(r.Shopping()), len(r.Threads), r.SkeinsToBuy)
+	if r.Priced {
+		summary += fmt.Sprintf(", estimated cost %.2f", r.Cost)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
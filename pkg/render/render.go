@@ -0,0 +1,309 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// Style selects how each cell of a chart is drawn.
+type Style int
+
+const (
+	// StyleFilled fills each cell with its thread color.
+	StyleFilled Style = iota
+	// StyleSymbol fills each cell and overlays the thread's symbol.
+	StyleSymbol
+	// StyleXStitch draws each cell as a cross stitch.
+	StyleXStitch
+)
+
+// Styles lists every style, in the order charts are saved.
+var Styles = []Style{StyleSymbol, StyleFilled, StyleXStitch}
+
+func (s Style) String() string {
+	switch s {
+	case StyleFilled:
+		return "filled"
+	case StyleSymbol:
+		return "symbol"
+	case StyleXStitch:
+		return "xstitch"
+	}
+	return fmt.Sprintf("Style(%d)", int(s))
+}
+
+// FileName is the name a chart in this style is saved under.
+func (s Style) FileName() string {
+	switch s {
+	case StyleSymbol:
+		return "filled_color_and_symbol.jpg"
+	case StyleXStitch:
+		return "x_stitch.jpg"
+	}
+	return "filled_color.jpg"
+}
+
+// ParseStyle returns the style with the given name.
+func ParseStyle(name string) (Style, error) {
+	for _, s := range Styles {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return StyleFilled, fmt.Errorf("unknown chart style: %s", name)
+}
+
+// Format is an image encoding a chart can be written in.
+type Format int
+
+const (
+	FormatPNG Format = iota
+	FormatJPEG
+)
+
+// FormatFromPath picks a format from a file extension.
+func FormatFromPath(path string) (Format, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png":
+		return FormatPNG, nil
+	case ".jpg", ".jpeg":
+		return FormatJPEG, nil
+	default:
+		return FormatPNG, fmt.Errorf("unsupported file format: %s", ext)
+	}
+}
+
+// LoadFont reads and parses a TrueType or OpenType font file.
+func LoadFont(path string) (*opentype.Font, error) {
+	fontBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.Parse(fontBytes)
+}
+
+// Renderer draws grids of thread colors as chart images.
+type Renderer struct {
+	CellSize        int
+	BorderThickness int
+	Style           Style
+	// Font draws symbols for StyleSymbol. Without it symbols are skipped.
+	Font *opentype.Font
+	// FabricColor fills empty cells in StyleFilled and StyleSymbol.
+	FabricColor color.RGBA
+}
+
+// NewRenderer returns a Renderer with the default 20px cells, 1px borders
+// and white fabric.
+func NewRenderer(fnt *opentype.Font, style Style) *Renderer {
+	return &Renderer{
+		CellSize:        20,
+		BorderThickness: 1,
+		Style:           style,
+		Font:            fnt,
+		FabricColor:     color.RGBA{R: 255, G: 255, B: 255, A: 255},
+	}
+}
+
+// WithStyle returns a copy of the renderer that draws in another style.
+func (r *Renderer) WithStyle(style Style) *Renderer {
+	c := *r
+	c.Style = style
+	return &c
+}
+
+// Render draws the grid as a chart image.
+func (r *Renderer) Render(grid [][]common.ThreadColor) image.Image {
+	numRows := len(grid)
+	numCols := 0
+	if numRows > 0 {
+		numCols = len(grid[0])
+	}
+
+	cellSize := r.CellSize
+	img := image.NewRGBA(image.Rect(0, 0, numCols*cellSize, numRows*cellSize))
+
+	var face font.Face
+	if r.Style == StyleSymbol && r.Font != nil {
+		f, err := opentype.NewFace(r.Font, &opentype.FaceOptions{
+			Size:    float64(cellSize),
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err == nil {
+			face = f
+			defer face.Close()
+		}
+	}
+
+	for row := 0; row < numRows; row++ {
+		for col := 0; col < numCols; col++ {
+			cell := grid[row][col]
+			x := col * cellSize
+			y := row * cellSize
+
+			// Empty cells show the fabric, without a stitch or symbol.
+			if cell.IsEmpty() {
+				if r.Style != StyleXStitch {
+					draw.Draw(img, image.Rect(x, y, x+cellSize, y+cellSize), &image.Uniform{r.FabricColor}, image.Point{}, draw.Src)
+				}
+				r.drawBorder(img, x, y)
+				continue
+			}
+
+			cellColor := cell.Color
+			cellColor.A = 255
+
+			// A blend is drawn in its two threads: one leg of the cross
+			// each, or the cell split along its diagonal.
+			color1, color2 := cellColor, cellColor
+			if cell.IsBlend() {
+				color1, color2 = cell.Blend[0].Color, cell.Blend[1].Color
+				color1.A, color2.A = 255, 255
+			}
+
+			if r.Style == StyleXStitch {
+				r.drawStitch(img, x, y, color1, color2)
+			} else if cell.IsBlend() {
+				r.drawSplit(img, x, y, color1, color2)
+			} else {
+				draw.Draw(img, image.Rect(x, y, x+cellSize, y+cellSize), &image.Uniform{cellColor}, image.Point{}, draw.Src)
+			}
+
+			if face != nil {
+				r.drawSymbol(img, face, x, y, cell)
+			}
+
+			r.drawBorder(img, x, y)
+		}
+	}
+
+	return img
+}
+
+// drawStitch draws two thick diagonals across the cell at (x, y), the one
+// from the top left in color1 and the one from the bottom left in color2.
+func (r *Renderer) drawStitch(img *image.RGBA, x, y int, color1, color2 color.RGBA) {
+	cellSize := r.CellSize
+	stitchThickness := 3
+
+	for i := 0; i < cellSize; i++ {
+		for t := 0; t < stitchThickness; t++ {
+			img.Set(x+i, y+i+t, color1)            // top left diagonal
+			img.Set(x+i, y+cellSize-1-i-t, color2) // bottom left diagonal
+			img.Set(x+i+t, y+i, color1)            // top left diagonal (offset)
+			img.Set(x+i+t, y+cellSize-1-i, color2) // bottom left diagonal (offset)
+		}
+	}
+}
+
+// drawSplit fills the cell at (x, y) above its top right to bottom left
+// diagonal with color1 and below it with color2.
+func (r *Renderer) drawSplit(img *image.RGBA, x, y int, color1, color2 color.RGBA) {
+	cellSize := r.CellSize
+	for j := 0; j < cellSize; j++ {
+		for i := 0; i < cellSize; i++ {
+			c := color1
+			if i+j >= cellSize {
+				c = color2
+			}
+			img.SetRGBA(x+i, y+j, c)
+		}
+	}
+}
+
+// drawSymbol writes the cell's symbol in black or white, whichever reads
+// better against the thread color.
+func (r *Renderer) drawSymbol(img *image.RGBA, face font.Face, x, y int, cell common.ThreadColor) {
+	cellSize := r.CellSize
+
+	fontColor := image.White
+	if (float32(cell.Color.R)*0.299 + float32(cell.Color.G)*0.587 + float32(cell.Color.B)*0.114) > 186 {
+		fontColor = image.Black
+	}
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  fontColor,
+		Face: face,
+	}
+	drawer.Dot = fixed.Point26_6{
+		X: fixed.I(x + cellSize/4),
+		Y: fixed.I(y + cellSize - cellSize/4),
+	}
+	drawer.DrawString(cell.Symbol)
+}
+
+// drawBorder outlines the cell at (x, y) in black.
+func (r *Renderer) drawBorder(img *image.RGBA, x, y int) {
+	cellSize := r.CellSize
+	borderColor := &image.Uniform{color.Black}
+	t := r.BorderThickness
+
+	draw.Draw(img, image.Rect(x, y, x+cellSize, y+t), borderColor, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(x, y, x+t, y+cellSize), borderColor, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(x, y+cellSize-t, x+cellSize, y+cellSize), borderColor, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(x+cellSize-t, y, x+cellSize, y+cellSize), borderColor, image.Point{}, draw.Src)
+}
+
+// Encode renders the grid and writes it to w in the given format.
+func (r *Renderer) Encode(w io.Writer, grid [][]common.ThreadColor, format Format) error {
+	img := r.Render(grid)
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, nil)
+	case FormatPNG:
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("unsupported format: %d", int(format))
+	}
+}
+
+// SaveFile renders the grid into a file, choosing the format from its
+// extension.
+func (r *Renderer) SaveFile(path string, grid [][]common.ThreadColor) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := r.Encode(file, grid, format); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SaveCharts renders the grid in each style and writes the charts into dir
+// under their style's file name, creating dir if needed.
+func (r *Renderer) SaveCharts(dir string, grid [][]common.ThreadColor, styles []Style) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, s := range styles {
+		if err := r.WithStyle(s).SaveFile(filepath.Join(dir, s.FileName()), grid); err != nil {
+			return fmt.Errorf("failed to save %s: %w", s.FileName(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package symbols
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// Drawable returns the symbols the font has a glyph for every rune of, in
+// order, and the symbols it lacks glyphs for. A symbol that draws the same
+// glyphs as an earlier one is dropped, since the two could not be told
+// apart on a chart.
+func Drawable(f *sfnt.Font, symbols []string) (drawable, missing []string, err error) {
+	var buf sfnt.Buffer
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		var glyphs strings.Builder
+		found := true
+		for _, r := range symbol {
+			index, err := f.GlyphIndex(&buf, r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to look up glyph for %q: %w", r, err)
+			}
+			if index == 0 {
+				found = false
+				break
+			}
+			fmt.Fprintf(&glyphs, "%d,", index)
+		}
+
+		switch {
+		case !found:
+			missing = append(missing, symbol)
+		case !seen[glyphs.String()]:
+			seen[glyphs.String()] = true
+			drawable = append(drawable, symbol)
+		}
+	}
+	return drawable, missing, nil
+}
+
+// AssignForFont assigns symbols like Assign, skipping symbols the font
+// cannot draw. It fails when the font can draw fewer distinct symbols than
+// the pattern has colors. A nil font assigns every symbol.
+func AssignForFont(p *common.Pattern, symbols []string, f *sfnt.Font) error {
+	if f == nil {
+		return Assign(p, symbols)
+	}
+	drawable, missing, err := Drawable(f, symbols)
+	if err != nil {
+		return err
+	}
+	if len(drawable) < len(p.Palette) {
+		msg := fmt.Sprintf("the font can draw only %d distinct symbols for a chart of %d colors", len(drawable), len(p.Palette))
+		if len(missing) > 0 {
+			quoted := make([]string, 0, 10)
+			for _, symbol := range missing {
+				if len(quoted) == 10 {
+					quoted = append(quoted, "...")
+					break
+				}
+				quoted = append(quoted, fmt.Sprintf("%+q", symbol))
+			}
+			msg += fmt.Sprintf("; no glyphs for %s", strings.Join(quoted, " "))
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return Assign(p, drawable)
+}
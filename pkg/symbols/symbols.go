@@ -0,0 +1,234 @@
+// Package symbols chooses the symbols printed on chart cells.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// Set is a built-in collection of chart symbols.
+type Set int
+
+const (
+	// SetSymbols mixes arrows, math operators and box drawing characters.
+	SetSymbols Set = iota
+	// SetLetters uses Latin letters and digits.
+	SetLetters
+	// SetShapes uses geometric shapes and card suits.
+	SetShapes
+	// SetCustom uses a list read from a file with LoadCustom.
+	SetCustom
+)
+
+// Sets lists every symbol set, in display order.
+var Sets = []Set{SetSymbols, SetLetters, SetShapes, SetCustom}
+
+func (s Set) String() string {
+	switch s {
+	case SetSymbols:
+		return "Symbols"
+	case SetLetters:
+		return "Letters"
+	case SetShapes:
+		return "Shapes"
+	case SetCustom:
+		return "Custom"
+	}
+	return fmt.Sprintf("Set(%d)", int(s))
+}
+
+// ParseSet returns the set whose name matches s, ignoring case.
+func ParseSet(s string) (Set, error) {
+	for _, set := range Sets {
+		if strings.EqualFold(set.String(), strings.TrimSpace(s)) {
+			return set, nil
+		}
+	}
+	return SetSymbols, fmt.Errorf("unknown symbol set: %s", s)
+}
+
+// Families of look-alike symbols. Families are listed most legible first,
+// and so are the symbols within each.
+var (
+	symbolFamilies = [][]string{
+		{"+", "×", "÷", "±", "∓"},
+		{"←", "↑", "→", "↓"},
+		{"=", "≠", "≈", "≡"},
+		{"∩", "∪", "⊂", "⊃"},
+		{"┌", "┐", "└", "┘"},
+		{"∞", "∝", "∅"},
+		{"<", ">", "≤", "≥"},
+		{"∧", "∨", "¬"},
+		{"├", "┤", "┬", "┴", "┼"},
+		{"∀", "∃", "∄"},
+		{"↔", "↕", "⇔", "⇕"},
+		{"∫", "∮", "∯"},
+		{"∈", "∋", "∉"},
+		{"√", "∛", "∜"},
+		{"⇐", "⇑", "⇒", "⇓"},
+		{"∑", "∏", "∐"},
+		{"║", "═", "╬", "╪", "╫"},
+		{"∇", "∆", "∂"},
+		{"↺", "↻", "⟲"},
+		{"∼", "≃", "≅"},
+	}
+
+	letterFamilies = [][]string{
+		{"A", "4"}, {"X", "x", "K", "k"}, {"H", "h", "N", "n"}, {"T", "7", "t", "Y", "y"},
+		{"M", "m", "W", "w"}, {"E", "F", "f"}, {"O", "0", "Q", "D", "o"}, {"S", "5", "s"},
+		{"Z", "2", "z"}, {"B", "8", "b"}, {"G", "6", "C", "c"}, {"R", "P", "p"},
+		{"V", "v", "U", "u"}, {"L", "J", "j"}, {"I", "1", "l", "i"}, {"3", "9", "g", "q"},
+		{"e", "a", "d"}, {"r"},
+	}
+
+	shapeFamilies = [][]string{
+		{"■", "□", "▪", "▫"},
+		{"●", "○", "◉", "◎"},
+		{"▲", "△", "▼", "▽"},
+		{"◆", "◇", "◈", "◊"},
+		{"★", "☆"},
+		{"♠", "♣", "♥", "♦"},
+		{"▶", "▷", "◀", "◁"},
+		{"▣", "▤", "▥", "▦", "▧", "▨", "▩"},
+		{"◐", "◑", "◒", "◓"},
+		{"◢", "◣", "◤", "◥"},
+		{"▬", "▭", "▮", "▯"},
+		{"♤", "♧", "♡", "♢"},
+		{"◧", "◨", "◩", "◪"},
+		{"◰", "◱", "◲", "◳"},
+		{"◴", "◵", "◶", "◷"},
+		{"◌", "◍"},
+	}
+)
+
+// fallbackRanges are the Unicode blocks chart symbols were originally drawn
+// from. They follow the curated symbols of every built-in set, so charts
+// with more colors than a set has curated symbols still get one per color.
+var fallbackRanges = [][2]rune{
+	{0x2190, 0x21FF}, // Arrows
+	{0x2200, 0x22FF}, // Mathematical Operators
+	{0x2500, 0x257F}, // Box Drawing
+}
+
+// Symbols returns the symbols of a built-in set in the order they are
+// handed out, or nil for SetCustom. The set's curated symbols come first,
+// then the rest of fallbackRanges.
+func (s Set) Symbols() []string {
+	var families [][]string
+	switch s {
+	case SetSymbols:
+		families = symbolFamilies
+	case SetLetters:
+		families = letterFamilies
+	case SetShapes:
+		families = shapeFamilies
+	default:
+		return nil
+	}
+
+	symbols := Order(families)
+	seen := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		seen[symbol] = true
+	}
+	for _, r := range fallbackRanges {
+		for c := r[0]; c <= r[1]; c++ {
+			if symbol := string(c); !seen[symbol] {
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	return symbols
+}
+
+// Order flattens families of look-alike symbols into the order they are
+// handed out: the first symbol of every family, then the second of every
+// family and so on. The most legible symbols come first and symbols that
+// are easily confused end up far apart.
+func Order(families [][]string) []string {
+	var symbols []string
+	for i := 0; ; i++ {
+		added := false
+		for _, family := range families {
+			if i < len(family) {
+				symbols = append(symbols, family[i])
+				added = true
+			}
+		}
+		if !added {
+			return symbols
+		}
+	}
+}
+
+// ReadCustom reads a custom symbol list. Each line holds one or more
+// symbols separated by spaces, and symbols on the same line are treated as
+// look-alikes to keep apart. Blank lines and lines starting with # are
+// ignored.
+func ReadCustom(r io.Reader) ([]string, error) {
+	var families [][]string
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		family := strings.Fields(text)
+		for _, symbol := range family {
+			if first, ok := seen[symbol]; ok {
+				return nil, fmt.Errorf("line %d: symbol %q already given on line %d", line, symbol, first)
+			}
+			seen[symbol] = line
+		}
+		families = append(families, family)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(families) == 0 {
+		return nil, fmt.Errorf("no symbols in list")
+	}
+	return Order(families), nil
+}
+
+// LoadCustom reads a custom symbol list file.
+func LoadCustom(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCustom(file)
+}
+
+// Assign gives every palette entry of the pattern a symbol from symbols,
+// handing the first symbols to the most used threads. It fails without
+// changing the pattern when there are fewer symbols than palette entries.
+func Assign(p *common.Pattern, symbols []string) error {
+	if len(symbols) < len(p.Palette) {
+		return fmt.Errorf("only %d symbols for a chart of %d colors", len(symbols), len(p.Palette))
+	}
+
+	order := make([]int, len(p.Palette))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return p.Palette[order[a]].Count > p.Palette[order[b]].Count
+	})
+	for k, i := range order {
+		p.Palette[i].Thread.Symbol = symbols[k]
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package symbols
+
+import "testing"
+
+func TestBuiltInSetsFallBackToUnicodeRanges(t *testing.T) {
+	for _, set := range []Set{SetSymbols, SetLetters, SetShapes} {
+		symbols := set.Symbols()
+		seen := make(map[string]bool)
+		for _, symbol := range symbols {
+			if seen[symbol] {
+				t.Errorf("%s: symbol %q handed out twice", set, symbol)
+			}
+			seen[symbol] = true
+		}
+		// The curated symbols lead, and every fallback codepoint follows.
+		if first := Order(map[Set][][]string{SetSymbols: symbolFamilies, SetLetters: letterFamilies, SetShapes: shapeFamilies}[set])[0]; symbols[0] != first {
+			t.Errorf("%s: first symbol = %q, want %q", set, symbols[0], first)
+		}
+		for _, r := range fallbackRanges {
+			for c := r[0]; c <= r[1]; c++ {
+				if !seen[string(c)] {
+					t.Errorf("%s: fallback symbol %q missing", set, string(c))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package threads
+
+import (
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// NewBlend returns the blended thread made by stitching with one strand
+// each of a and b.
+func NewBlend(a, b common.ThreadColor) common.ThreadColor {
+	strand := func(tc common.ThreadColor) common.Strand {
+		return common.Strand{ID: tc.ID, Name: tc.Name, Brand: tc.Brand, Color: tc.Color}
+	}
+	return common.ThreadColor{
+		Name:  a.Name + " + " + b.Name,
+		Color: colormath.MixRGB(a.Color, b.Color),
+		Blend: [2]common.Strand{strand(a), strand(b)},
+	}
+}
@@ -0,0 +1,148 @@
+package threads
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/colormath"
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// DefaultMismatchThreshold is the CIEDE2000 difference above which a
+// converted thread is reported as a poor match. Differences under about 2
+// are hard to see side by side.
+const DefaultMismatchThreshold = 5.0
+
+// Conversion records the thread one palette entry was converted to.
+type Conversion struct {
+	From, To common.ThreadColor
+	Stitches int
+	// DeltaE is the CIEDE2000 difference between the two threads.
+	DeltaE float64
+	// Mismatch is set when DeltaE exceeds the report's threshold.
+	Mismatch bool
+}
+
+// ConversionReport describes how a chart was converted to another brand.
+type ConversionReport struct {
+	FromBrands []string
+	ToBrand    string
+	Threshold  float64
+	// Conversions holds one entry per used thread of the original chart,
+	// in palette order.
+	Conversions []Conversion
+	// Merged counts original threads that converted to a thread another
+	// original thread had already converted to.
+	Merged int
+}
+
+// Mismatches returns the conversions whose difference exceeds the
+// threshold.
+func (r ConversionReport) Mismatches() []Conversion {
+	var out []Conversion
+	for _, c := range r.Conversions {
+		if c.Mismatch {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Convert maps every thread of a pattern to its nearest equivalent in the
+// target library and returns the converted copy with a report. Threads
+// that convert to the same target are merged. Symbols are kept so the
+// converted chart reads like the original, and each strand of a blend is
+// converted on its own. A threshold of zero or less
+// uses DefaultMismatchThreshold.
+func Convert(p *common.Pattern, target *Library, metric colormath.Metric, threshold float64) (*common.Pattern, ConversionReport) {
+	if threshold <= 0 {
+		threshold = DefaultMismatchThreshold
+	}
+	report := ConversionReport{ToBrand: target.Brand, Threshold: threshold}
+	matcher := colormath.NewMatcher(target.Threads, metric)
+
+	out := &common.Pattern{
+		Width:    p.Width,
+		Height:   p.Height,
+		Cells:    make([]int, len(p.Cells)),
+		Metadata: p.Metadata,
+	}
+	remap := make([]int, len(p.Palette))
+	targets := make(map[string]int) // target thread code to palette index in out
+	fromBrands := make(map[string]bool)
+
+	for i, entry := range p.Palette {
+		from := entry.Thread
+		to := matcher.Nearest(from.Color)
+		if from.IsBlend() {
+			parts := from.Parts()
+			to = NewBlend(matcher.Nearest(parts[0].Color), matcher.Nearest(parts[1].Color))
+		}
+		to.Symbol = from.Symbol
+
+		if j, ok := targets[to.Code()]; ok {
+			remap[i] = j
+			if entry.Count > 0 {
+				report.Merged++
+			}
+		} else {
+			remap[i] = len(out.Palette)
+			targets[to.Code()] = remap[i]
+			out.Palette = append(out.Palette, common.PaletteEntry{Thread: to})
+		}
+
+		if entry.Count == 0 {
+			continue
+		}
+		if !fromBrands[from.BrandName()] {
+			fromBrands[from.BrandName()] = true
+			report.FromBrands = append(report.FromBrands, from.BrandName())
+		}
+		dE := colormath.CIEDE2000(colormath.ToLab(from.Color), colormath.ToLab(to.Color))
+		report.Conversions = append(report.Conversions, Conversion{
+			From:     from,
+			To:       out.Palette[remap[i]].Thread,
+			Stitches: entry.Count,
+			DeltaE:   dE,
+			Mismatch: dE > threshold,
+		})
+	}
+
+	for i, cell := range p.Cells {
+		if cell == common.EmptyCell {
+			out.Cells[i] = common.EmptyCell
+		} else {
+			out.Cells[i] = remap[cell]
+		}
+	}
+	for _, b := range p.Backstitches {
+		b.PaletteIndex = remap[b.PaletteIndex]
+		out.Backstitches = append(out.Backstitches, b)
+	}
+	out.Recount()
+
+	return out, report
+}
+
+// WriteText writes the report as a table of conversions followed by a
+// summary, marking poor matches with "!".
+func (r ConversionReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "From\tName\tTo\tName\tStitches\tΔE\t\n")
+	for _, c := range r.Conversions {
+		flag := ""
+		if c.Mismatch {
+			flag = "!"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%.1f\t%s\n", c.From.Code(), c.From.Name, c.To.Code(), c.To.Name, c.Stitches, c.DeltaE, flag)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "Converted %d threads from %s to %s: %d over ΔE %.1f, %d merged\n",
+		len(r.Conversions), strings.Join(r.FromBrands, ", "), r.ToBrand, len(r.Mismatches()), r.Threshold, r.Merged)
+	return err
+}
@@ -0,0 +1,416 @@
+package threads
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// Format is a palette file format.
+type Format int
+
+const (
+	// FormatTSV is the original tab separated format: ID, name, red,
+	// green, blue and an optional hex color per line.
+	FormatTSV Format = iota
+	// FormatCSV has the same columns separated by commas, with an
+	// optional header row naming them.
+	FormatCSV
+	// FormatJSON is an array of objects with id, name, r, g, b and an
+	// optional hex field.
+	FormatJSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatTSV:
+		return "tsv"
+	case FormatCSV:
+		return "csv"
+	case FormatJSON:
+		return "json"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// FormatFromPath picks a palette format from a file extension. Unknown
+// extensions, including .txt, are read as tab separated.
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".json":
+		return FormatJSON
+	}
+	return FormatTSV
+}
+
+// ParseError is a problem with one line of a palette file.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is every problem found in a palette file.
+type ParseErrors []*ParseError
+
+// maxReportedErrors limits how many errors ParseErrors.Error lists.
+const maxReportedErrors = 10
+
+func (e ParseErrors) Error() string {
+	lines := make([]string, 0, min(len(e), maxReportedErrors)+1)
+	for i, err := range e {
+		if i == maxReportedErrors {
+			lines = append(lines, fmt.Sprintf("and %d more", len(e)-i))
+			break
+		}
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paletteRow is one thread as read from a file, before validation.
+type paletteRow struct {
+	line    int
+	id      string
+	name    string
+	r, g, b string
+	hex     string
+}
+
+// LoadPalette reads a palette file, choosing the format from its
+// extension.
+func LoadPalette(path string) ([]common.ThreadColor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	threadColors, err := ParsePalette(file, FormatFromPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return threadColors, nil
+}
+
+// ParsePalette reads a palette and assigns each thread a symbol. Every
+// line is checked: IDs must be unique non-negative integers, names must
+// not be empty, RGB values must be 0-255 and a hex color, when present,
+// must agree with them. Any problems are returned together as ParseErrors.
+func ParsePalette(r io.Reader, format Format) ([]common.ThreadColor, error) {
+	var rows []paletteRow
+	var errs ParseErrors
+	var err error
+	switch format {
+	case FormatTSV:
+		rows, errs, err = readTSV(r)
+	case FormatCSV:
+		rows, errs, err = readCSV(r)
+	case FormatJSON:
+		rows, errs, err = readJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported palette format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var threadColors []common.ThreadColor
+	seen := make(map[int]int)
+	for _, row := range rows {
+		tc, err := row.thread()
+		if err != nil {
+			errs = append(errs, &ParseError{Line: row.line, Err: err})
+			continue
+		}
+		if first, ok := seen[tc.ID]; ok {
+			errs = append(errs, &ParseError{Line: row.line, Err: fmt.Errorf("duplicate thread ID %d, first on line %d", tc.ID, first)})
+			continue
+		}
+		seen[tc.ID] = row.line
+		threadColors = append(threadColors, tc)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
+	}
+	if len(threadColors) == 0 {
+		return nil, errors.New("palette has no threads")
+	}
+
+	AssignSymbols(threadColors)
+	return threadColors, nil
+}
+
+// thread validates the row and converts it into a thread.
+func (row paletteRow) thread() (common.ThreadColor, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(row.id))
+	if err != nil || id < 0 {
+		return common.ThreadColor{}, fmt.Errorf("invalid thread ID %q", row.id)
+	}
+	name := strings.TrimSpace(row.name)
+	if name == "" {
+		return common.ThreadColor{}, fmt.Errorf("thread %d has no name", id)
+	}
+
+	var rgb [3]uint8
+	for i, v := range []string{row.r, row.g, row.b} {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 || n > 255 {
+			return common.ThreadColor{}, fmt.Errorf("thread %d: %s value %q is not between 0 and 255", id, "RGB"[i:i+1], v)
+		}
+		rgb[i] = uint8(n)
+	}
+	c := color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2]}
+
+	if hex := strings.TrimPrefix(strings.TrimSpace(row.hex), "#"); hex != "" {
+		var h color.RGBA
+		if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &h.R, &h.G, &h.B); err != nil || len(hex) != 6 {
+			return common.ThreadColor{}, fmt.Errorf("thread %d: invalid hex color %q", id, row.hex)
+		}
+		if h != c {
+			return common.ThreadColor{}, fmt.Errorf("thread %d: hex color %s does not match RGB %d, %d, %d", id, hex, c.R, c.G, c.B)
+		}
+	}
+
+	return common.ThreadColor{ID: id, Name: name, Color: c}, nil
+}
+
+// skipLine reports whether a line is blank or a # comment.
+func skipLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// readTSV reads tab separated lines of ID, name, red, green, blue and an
+// optional hex color. Names may themselves contain tabs.
+func readTSV(r io.Reader) ([]paletteRow, ParseErrors, error) {
+	var rows []paletteRow
+	var errs ParseErrors
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if skipLine(text) {
+			continue
+		}
+
+		parts := strings.FieldsFunc(text, func(r rune) bool {
+			return r == '\t'
+		})
+		switch {
+		case len(parts) == 5:
+			rows = append(rows, paletteRow{line: line, id: parts[0], name: parts[1], r: parts[2], g: parts[3], b: parts[4]})
+		case len(parts) >= 6:
+			n := len(parts)
+			rows = append(rows, paletteRow{
+				line: line,
+				id:   parts[0],
+				name: strings.Join(parts[1:n-4], " "),
+				r:    parts[n-4], g: parts[n-3], b: parts[n-2],
+				hex: parts[n-1],
+			})
+		default:
+			errs = append(errs, &ParseError{Line: line, Err: fmt.Errorf("want 5 or 6 tab separated columns, got %d", len(parts))})
+		}
+	}
+	return rows, errs, scanner.Err()
+}
+
+// csvColumns maps header names to the columns they hold.
+var csvColumns = map[string]string{
+	"id": "id", "number": "id", "code": "id",
+	"name": "name", "description": "name",
+	"r": "r", "red": "r",
+	"g": "g", "green": "g",
+	"b": "b", "blue": "b",
+	"hex": "hex", "color": "hex", "colour": "hex", "rgb": "hex",
+}
+
+// readCSV reads comma separated rows. A first row whose ID column is not a
+// number is taken as a header naming the columns; otherwise the columns are
+// ID, name, red, green, blue and an optional hex color.
+func readCSV(r io.Reader) ([]paletteRow, ParseErrors, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	order := []string{"id", "name", "r", "g", "b", "hex"}
+	index := func(cols []string) map[string]int {
+		m := make(map[string]int)
+		for i, c := range cols {
+			m[c] = i
+		}
+		return m
+	}
+	columns := index(order)
+
+	var rows []paletteRow
+	var errs ParseErrors
+	first := true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				errs = append(errs, &ParseError{Line: perr.Line, Err: perr.Err})
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		if first {
+			first = false
+			if _, err := strconv.Atoi(strings.TrimSpace(record[0])); err != nil {
+				header := make([]string, len(record))
+				for i, name := range record {
+					header[i] = csvColumns[strings.ToLower(strings.TrimSpace(name))]
+				}
+				columns = index(header)
+				for _, required := range []string{"id", "name", "r", "g", "b"} {
+					if _, ok := columns[required]; !ok {
+						return nil, nil, &ParseError{Line: line, Err: fmt.Errorf("header has no %s column", required)}
+					}
+				}
+				continue
+			}
+		}
+
+		get := func(col string) string {
+			if i, ok := columns[col]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		if len(record) < 5 {
+			errs = append(errs, &ParseError{Line: line, Err: fmt.Errorf("want at least 5 columns, got %d", len(record))})
+			continue
+		}
+		rows = append(rows, paletteRow{line: line, id: get("id"), name: get("name"), r: get("r"), g: get("g"), b: get("b"), hex: get("hex")})
+	}
+	return rows, errs, nil
+}
+
+type jsonThread struct {
+	ID   json.Number `json:"id"`
+	Name string      `json:"name"`
+	R    json.Number `json:"r"`
+	G    json.Number `json:"g"`
+	B    json.Number `json:"b"`
+	Hex  string      `json:"hex"`
+}
+
+// readJSON reads an array of thread objects, recording the line each one
+// starts on.
+func readJSON(r io.Reader) ([]paletteRow, ParseErrors, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+		return nil, nil, &ParseError{Line: lineAt(decoder.InputOffset()), Err: errors.New("palette must be a JSON array of threads")}
+	}
+
+	var rows []paletteRow
+	var errs ParseErrors
+	for decoder.More() {
+		// InputOffset is just past the previous element; skip the comma
+		// and whitespace so the line is the one the object starts on.
+		offset := decoder.InputOffset()
+		for offset < int64(len(data)) && bytes.IndexByte([]byte(", \t\r\n"), data[offset]) >= 0 {
+			offset++
+		}
+
+		var t jsonThread
+		if err := decoder.Decode(&t); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				field := typeErr.Field
+				if field == "" {
+					field = "a field"
+				}
+				errs = append(errs, &ParseError{Line: lineAt(offset), Err: fmt.Errorf("wrong type for %s: got %s", field, typeErr.Value)})
+				continue
+			}
+			return nil, errs, &ParseError{Line: lineAt(decoder.InputOffset()), Err: err}
+		}
+		rows = append(rows, paletteRow{line: lineAt(offset), id: t.ID.String(), name: t.Name, r: t.R.String(), g: t.G.String(), b: t.B.String(), hex: t.Hex})
+	}
+	return rows, errs, nil
+}
+
+// symbolRanges are the Unicode blocks symbols are drawn from.
+var symbolRanges = [][2]rune{
+	{0x2190, 0x21FF}, // Arrows: U+2190 to U+21FF
+	{0x2200, 0x22FF}, // Mathematical Operators: U+2200 to U+22FF
+	{0x2500, 0x257F}, // Box Drawing: U+2500 to U+257F
+}
+
+// AssignSymbols gives each thread a distinct symbol in palette order.
+// Threads beyond the available symbols are left without one.
+func AssignSymbols(threadColors []common.ThreadColor) {
+	i := 0
+	for _, r := range symbolRanges {
+		for c := r[0]; c <= r[1] && i < len(threadColors); c++ {
+			threadColors[i].Symbol = string(c)
+			i++
+		}
+	}
+}
+
+// UnusedSymbols returns up to n symbols that none of the threads use yet,
+// in the order AssignSymbols hands them out.
+func UnusedSymbols(threadColors []common.ThreadColor, n int) []string {
+	used := make(map[string]bool)
+	for _, tc := range threadColors {
+		used[tc.Symbol] = true
+	}
+
+	var symbols []string
+	for _, r := range symbolRanges {
+		for c := r[0]; c <= r[1] && len(symbols) < n; c++ {
+			if !used[string(c)] {
+				symbols = append(symbols, string(c))
+			}
+		}
+	}
+	return symbols
+}
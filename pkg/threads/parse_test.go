@@ -0,0 +1,55 @@
+package threads
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsePaletteCSV(t *testing.T) {
+	input := "number,description,red,green,blue\n310,Black,0,0,0\n# comment\n\n3865,Winter White,249,247,241\n"
+	threadColors, err := ParsePalette(strings.NewReader(input), FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threadColors) != 2 {
+		t.Fatalf("got %d threads, want 2", len(threadColors))
+	}
+	if tc := threadColors[1]; tc.ID != 3865 || tc.Name != "Winter White" || tc.Color.R != 249 || tc.Color.G != 247 || tc.Color.B != 241 {
+		t.Errorf("second thread = %+v", tc)
+	}
+}
+
+func TestParsePaletteMalformedCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines []int
+	}{
+		{"bare quote", "a\"b,x,1,2,3\n", []int{1}},
+		{"bare quote after a good line", "310,Black,0,0,0\n310\"1,x,1,2,3\n3865,Winter White,249,247,241\n", []int{2}},
+		{"unterminated quote", "\"310,Black,0,0,0\n", []int{1}},
+		{"unterminated quote after a good line", "310,Black,0,0,0\n\"3865,Winter White,249,247,241\n", []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePalette(strings.NewReader(tt.input), FormatCSV)
+			var errs ParseErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got error %v, want ParseErrors", err)
+			}
+			var lines []int
+			for _, e := range errs {
+				lines = append(lines, e.Line)
+			}
+			if len(lines) != len(tt.lines) {
+				t.Fatalf("errors on lines %v, want %v", lines, tt.lines)
+			}
+			for i := range lines {
+				if lines[i] != tt.lines[i] {
+					t.Fatalf("errors on lines %v, want %v", lines, tt.lines)
+				}
+			}
+		})
+	}
+}
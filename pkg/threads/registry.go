@@ -0,0 +1,133 @@
+// Package threads manages thread libraries from different brands and
+// converts charts between them.
+package threads
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Kytlin/Cross-stitch-image-generator/pkg/common"
+)
+
+// BrandDMC is the brand of the thread library shipped in assets.
+const BrandDMC = "DMC"
+
+// LibraryFiles maps each brand shipped in the assets directory to its
+// palette file. DMC keeps the original thread_colors.txt name. Other brands
+// are loaded as custom libraries until their palettes are added here.
+var LibraryFiles = map[string]string{
+	BrandDMC: "thread_colors.txt",
+}
+
+// Library is the set of threads one brand sells.
+type Library struct {
+	Brand   string
+	Threads []common.ThreadColor
+}
+
+// NewLibrary returns a library of the given threads, setting each thread's
+// brand.
+func NewLibrary(brand string, threadColors []common.ThreadColor) *Library {
+	lib := &Library{Brand: brand, Threads: make([]common.ThreadColor, len(threadColors))}
+	for i, tc := range threadColors {
+		tc.Brand = brand
+		lib.Threads[i] = tc
+	}
+	return lib
+}
+
+// LoadLibrary reads a brand's palette file.
+func LoadLibrary(brand, path string) (*Library, error) {
+	threadColors, err := LoadPalette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLibrary(brand, threadColors), nil
+}
+
+// Find returns the thread with the given ID.
+func (l *Library) Find(id int) (common.ThreadColor, bool) {
+	for _, tc := range l.Threads {
+		if tc.ID == id {
+			return tc, true
+		}
+	}
+	return common.ThreadColor{}, false
+}
+
+// Registry holds the thread libraries available for charts, keyed by brand
+// name without regard to case.
+type Registry struct {
+	libraries map[string]*Library
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{libraries: make(map[string]*Library)}
+}
+
+// Register adds a library, replacing any library of the same brand.
+func (r *Registry) Register(lib *Library) {
+	r.libraries[strings.ToLower(lib.Brand)] = lib
+}
+
+// Library returns the library of a brand.
+func (r *Registry) Library(brand string) (*Library, error) {
+	lib, ok := r.libraries[strings.ToLower(brand)]
+	if !ok {
+		return nil, fmt.Errorf("no thread library for brand %q", brand)
+	}
+	return lib, nil
+}
+
+// Brands lists the registered brands, DMC first and the rest
+// alphabetically.
+func (r *Registry) Brands() []string {
+	brands := make([]string, 0, len(r.libraries))
+	for _, lib := range r.libraries {
+		brands = append(brands, lib.Brand)
+	}
+	sort.Slice(brands, func(i, j int) bool {
+		if strings.EqualFold(brands[i], BrandDMC) != strings.EqualFold(brands[j], BrandDMC) {
+			return strings.EqualFold(brands[i], BrandDMC)
+		}
+		return strings.ToLower(brands[i]) < strings.ToLower(brands[j])
+	})
+	return brands
+}
+
+// LoadRegistry registers the library of every known brand whose palette
+// file exists in dir. Brands without a file are skipped. Libraries that
+// fail to parse are left out and their errors returned together, alongside
+// the registry of the libraries that loaded.
+func LoadRegistry(dir string) (*Registry, error) {
+	r := NewRegistry()
+	var errs []error
+	for brand, name := range LibraryFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		lib, err := LoadLibrary(brand, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load %s library: %w", brand, err))
+			continue
+		}
+		r.Register(lib)
+	}
+	return r, errors.Join(errs...)
+}
+
+// ParseLibrarySpec splits a custom library given as "Brand=path".
+func ParseLibrarySpec(spec string) (brand, path string, err error) {
+	brand, path, ok := strings.Cut(spec, "=")
+	brand, path = strings.TrimSpace(brand), strings.TrimSpace(path)
+	if !ok || brand == "" || path == "" {
+		return "", "", fmt.Errorf("library must be given as Brand=path, got %q", spec)
+	}
+	return brand, path, nil
+}